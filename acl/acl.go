@@ -0,0 +1,279 @@
+// Package acl matches packets against 5-tuple rules of source and destination
+// prefixes, source and destination port ranges and protocol, built on
+// lpmtrie.
+//
+// Rules are compiled into a read-only Table by the bit vector scheme: each
+// field has a trie of the prefixes of the rules, whose values are the sets of
+// rules matching the prefix, pushed down from the covering prefixes. Port
+// ranges are converted into prefixes, and protocols are matched exactly. So
+// a packet is matched by a lookup per field, and the first rule in all the
+// sets.
+package acl
+
+import (
+	"errors"
+	"math/bits"
+	"sort"
+
+	"github.com/Asphaltt/lpmtrie"
+)
+
+// AnyProtocol is the Protocol of rules matching all protocols.
+const AnyProtocol = -1
+
+// PortRange is the inclusive range of ports [First, Last].
+type PortRange struct {
+	First, Last uint16
+}
+
+// AnyPort is the PortRange of all ports.
+var AnyPort = PortRange{0, 65535}
+
+// Rule matches the packets of which all the fields are matched.
+type Rule struct {
+	Src, Dst           lpmtrie.Key
+	SrcPorts, DstPorts PortRange
+
+	// Protocol is the IP protocol number, or AnyProtocol.
+	Protocol int
+
+	// Priority orders the rules, the higher the earlier. Rules of the same
+	// priority are in the order they are added. A packet matches the first
+	// rule matching it.
+	Priority int
+
+	Action interface{}
+}
+
+// Packet is the 5-tuple of a packet.
+type Packet struct {
+	Src, Dst         []byte
+	SrcPort, DstPort uint16
+	Protocol         uint8
+}
+
+// ACL is a list of rules to be compiled.
+type ACL struct {
+	maxPrefixLen int
+	rules        []Rule
+}
+
+// New creates an ACL of addresses of maxPrefixLen bits.
+func New(maxPrefixLen int) (*ACL, error) {
+	if _, err := lpmtrie.New(maxPrefixLen); err != nil {
+		return nil, err
+	}
+	return &ACL{maxPrefixLen: maxPrefixLen}, nil
+}
+
+// Add appends the rule to the ACL.
+func (a *ACL) Add(r Rule) error {
+	if !a.isValidKey(r.Src) || !a.isValidKey(r.Dst) {
+		return errors.New("acl: invalid prefix of rule")
+	}
+	if r.SrcPorts.First > r.SrcPorts.Last || r.DstPorts.First > r.DstPorts.Last {
+		return errors.New("acl: invalid port range of rule")
+	}
+	if r.Protocol < AnyProtocol || r.Protocol > 255 {
+		return errors.New("acl: invalid protocol of rule")
+	}
+
+	r.Src = copyKey(r.Src)
+	r.Dst = copyKey(r.Dst)
+	a.rules = append(a.rules, r)
+	return nil
+}
+
+// Len returns the number of rules.
+func (a *ACL) Len() int {
+	return len(a.rules)
+}
+
+// Table is the read-only lookup structure compiled from an ACL, which is
+// safe for concurrent use.
+type Table struct {
+	maxPrefixLen int
+	rules        []Rule // in the order of matching
+
+	src, dst         lpmtrie.LpmTrie
+	srcPort, dstPort lpmtrie.LpmTrie
+	protocols        [256]bitset
+}
+
+// Compile compiles the rules into a Table. The later changes of the ACL
+// don't affect the Table.
+func (a *ACL) Compile() (*Table, error) {
+	t := Table{maxPrefixLen: a.maxPrefixLen, rules: make([]Rule, len(a.rules))}
+	copy(t.rules, a.rules)
+	sort.SliceStable(t.rules, func(i, j int) bool {
+		return t.rules[i].Priority > t.rules[j].Priority
+	})
+
+	var err error
+	if t.src, err = t.buildField(a.maxPrefixLen, func(r *Rule) ([]lpmtrie.Key, error) {
+		return []lpmtrie.Key{r.Src}, nil
+	}); err != nil {
+		return nil, err
+	}
+	if t.dst, err = t.buildField(a.maxPrefixLen, func(r *Rule) ([]lpmtrie.Key, error) {
+		return []lpmtrie.Key{r.Dst}, nil
+	}); err != nil {
+		return nil, err
+	}
+	if t.srcPort, err = t.buildField(16, func(r *Rule) ([]lpmtrie.Key, error) {
+		return portPrefixes(r.SrcPorts)
+	}); err != nil {
+		return nil, err
+	}
+	if t.dstPort, err = t.buildField(16, func(r *Rule) ([]lpmtrie.Key, error) {
+		return portPrefixes(r.DstPorts)
+	}); err != nil {
+		return nil, err
+	}
+
+	for p := range t.protocols {
+		t.protocols[p] = newBitset(len(t.rules))
+	}
+	for i, r := range t.rules {
+		if r.Protocol == AnyProtocol {
+			for p := range t.protocols {
+				t.protocols[p].set(i)
+			}
+		} else {
+			t.protocols[r.Protocol].set(i)
+		}
+	}
+
+	return &t, nil
+}
+
+// buildField builds the trie of a field of maxPrefixLen bits, whose values
+// are the sets of the rules matching the prefixes.
+func (t *Table) buildField(maxPrefixLen int, prefixesOf func(r *Rule) ([]lpmtrie.Key, error)) (lpmtrie.LpmTrie, error) {
+	own, err := lpmtrie.New(maxPrefixLen)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range t.rules {
+		prefixes, err := prefixesOf(&t.rules[i])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, prefix := range prefixes {
+			set, ok := exactLookup(own, prefix)
+			if !ok {
+				set = newBitset(len(t.rules))
+				own.Update(prefix, set)
+			}
+			set.set(i)
+		}
+	}
+
+	// Push the sets down to the more specific prefixes, so that the longest
+	// prefix matching a value holds all the rules matching it.
+	field, _ := lpmtrie.New(maxPrefixLen)
+	own.Range(func(prefix lpmtrie.Key, _ interface{}) bool {
+		set := newBitset(len(t.rules))
+		own.Supernets(prefix, func(_ lpmtrie.Key, val interface{}) bool {
+			set.or(val.(bitset))
+			return true
+		})
+		field.Update(prefix, set)
+		return true
+	})
+
+	return field, nil
+}
+
+// Len returns the number of rules.
+func (t *Table) Len() int {
+	return len(t.rules)
+}
+
+// Match returns the first rule matching the packet. The lengths of the
+// addresses of the packet must be same with eighth of max prefix length
+// rounded up, or it will panic.
+func (t *Table) Match(p Packet) (Rule, bool) {
+	sets := [4]bitset{}
+	var ok bool
+	if sets[0], ok = lookupSet(t.src, lpmtrie.Key{PrefixLen: t.maxPrefixLen, Data: p.Src}); !ok {
+		return Rule{}, false
+	}
+	if sets[1], ok = lookupSet(t.dst, lpmtrie.Key{PrefixLen: t.maxPrefixLen, Data: p.Dst}); !ok {
+		return Rule{}, false
+	}
+	if sets[2], ok = lookupSet(t.srcPort, portKey(p.SrcPort)); !ok {
+		return Rule{}, false
+	}
+	if sets[3], ok = lookupSet(t.dstPort, portKey(p.DstPort)); !ok {
+		return Rule{}, false
+	}
+
+	proto := t.protocols[p.Protocol]
+	for w := range proto {
+		word := proto[w] & sets[0][w] & sets[1][w] & sets[2][w] & sets[3][w]
+		if word != 0 {
+			return t.rules[w*64+bits.TrailingZeros64(word)], true
+		}
+	}
+
+	return Rule{}, false
+}
+
+func lookupSet(trie lpmtrie.LpmTrie, key lpmtrie.Key) (bitset, bool) {
+	v, ok := trie.Lookup(key)
+	if !ok {
+		return nil, false
+	}
+	return v.(bitset), true
+}
+
+// exactLookup returns the set of exactly the prefix in trie.
+func exactLookup(trie lpmtrie.LpmTrie, prefix lpmtrie.Key) (set bitset, found bool) {
+	trie.Supernets(prefix, func(key lpmtrie.Key, val interface{}) bool {
+		if key.PrefixLen == prefix.PrefixLen {
+			set, found = val.(bitset), true
+		}
+		return true
+	})
+	return set, found
+}
+
+func portKey(port uint16) lpmtrie.Key {
+	return lpmtrie.Key{PrefixLen: 16, Data: []byte{byte(port >> 8), byte(port)}}
+}
+
+// portPrefixes converts the port range into the minimal set of prefixes.
+func portPrefixes(r PortRange) ([]lpmtrie.Key, error) {
+	first, last := portKey(r.First), portKey(r.Last)
+	return lpmtrie.RangeToPrefixes(first.Data, last.Data)
+}
+
+func (a *ACL) isValidKey(key lpmtrie.Key) bool {
+	return 0 <= key.PrefixLen && key.PrefixLen <= a.maxPrefixLen && len(key.Data) == (a.maxPrefixLen+7)/8
+}
+
+func copyKey(key lpmtrie.Key) lpmtrie.Key {
+	data := make([]byte, len(key.Data))
+	copy(data, key.Data)
+	return lpmtrie.Key{PrefixLen: key.PrefixLen, Data: data}
+}
+
+// bitset is a set of rules by their indices.
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (s bitset) set(i int) {
+	s[i/64] |= 1 << (i % 64)
+}
+
+func (s bitset) or(o bitset) {
+	for i := range s {
+		s[i] |= o[i]
+	}
+}
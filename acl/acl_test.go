@@ -0,0 +1,200 @@
+package acl
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/Asphaltt/lpmtrie"
+)
+
+func prefix(plen int, data ...byte) lpmtrie.Key {
+	return lpmtrie.Key{PrefixLen: plen, Data: data}
+}
+
+var anyAddr = prefix(0, 0, 0, 0, 0)
+
+func TestTable(t *testing.T) {
+	a, err := New(lpmtrie.MaxPrefixLenIPv4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rules := []Rule{
+		{anyAddr, anyAddr, AnyPort, AnyPort, AnyProtocol, 0, "deny"},
+		{prefix(8, 10, 0, 0, 0), prefix(24, 192, 168, 1, 0), AnyPort, PortRange{80, 80}, 6, 10, "http"},
+		{prefix(8, 10, 0, 0, 0), prefix(24, 192, 168, 1, 0), AnyPort, PortRange{8000, 8999}, 6, 10, "alt http"},
+		{anyAddr, prefix(32, 192, 168, 1, 53), AnyPort, PortRange{53, 53}, 17, 20, "dns"},
+		{prefix(16, 10, 66, 0, 0), anyAddr, AnyPort, AnyPort, AnyProtocol, 30, "blocked"},
+		{anyAddr, anyAddr, PortRange{1024, 65535}, PortRange{1024, 65535}, 17, 5, "high udp"},
+	}
+	for _, r := range rules {
+		if err := a.Add(r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	table, err := a.Compile()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = a.Add(Rule{anyAddr, anyAddr, AnyPort, AnyPort, AnyProtocol, 100, "late"})
+	if table.Len() != len(rules) {
+		t.Errorf("expected table not to be changed by later rules, got %d rules", table.Len())
+	}
+
+	tests := []struct {
+		p      Packet
+		expect string
+	}{
+		{Packet{[]byte{10, 1, 1, 1}, []byte{192, 168, 1, 10}, 40000, 80, 6}, "http"},
+		{Packet{[]byte{10, 1, 1, 1}, []byte{192, 168, 1, 10}, 40000, 8080, 6}, "alt http"},
+		{Packet{[]byte{10, 1, 1, 1}, []byte{192, 168, 1, 10}, 40000, 9000, 6}, "deny"},
+		{Packet{[]byte{10, 1, 1, 1}, []byte{192, 168, 1, 10}, 40000, 80, 17}, "deny"},
+		{Packet{[]byte{10, 1, 1, 1}, []byte{192, 168, 1, 53}, 40000, 53, 17}, "dns"},
+		{Packet{[]byte{10, 66, 1, 1}, []byte{192, 168, 1, 53}, 40000, 53, 17}, "blocked"},
+		{Packet{[]byte{11, 1, 1, 1}, []byte{8, 8, 8, 8}, 40000, 5000, 17}, "high udp"},
+		{Packet{[]byte{11, 1, 1, 1}, []byte{8, 8, 8, 8}, 40000, 1023, 17}, "deny"},
+	}
+	for _, tt := range tests {
+		r, ok := table.Match(tt.p)
+		if !ok || r.Action != tt.expect {
+			t.Errorf("expected %+v to match %q, got %v", tt.p, tt.expect, r.Action)
+		}
+	}
+}
+
+func TestTableNoMatch(t *testing.T) {
+	a, _ := New(lpmtrie.MaxPrefixLenIPv4)
+	table, _ := a.Compile()
+	if _, ok := table.Match(Packet{[]byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}, 1, 2, 6}); ok {
+		t.Errorf("expected empty table not to match")
+	}
+
+	_ = a.Add(Rule{prefix(8, 10, 0, 0, 0), anyAddr, AnyPort, PortRange{22, 22}, 6, 0, "ssh"})
+	table, _ = a.Compile()
+	for _, p := range []Packet{
+		{[]byte{11, 0, 0, 1}, []byte{10, 0, 0, 2}, 1, 22, 6},
+		{[]byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}, 1, 23, 6},
+		{[]byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}, 1, 22, 17},
+	} {
+		if _, ok := table.Match(p); ok {
+			t.Errorf("expected %+v not to match", p)
+		}
+	}
+}
+
+func TestAddInvalid(t *testing.T) {
+	a, _ := New(lpmtrie.MaxPrefixLenIPv4)
+	for _, r := range []Rule{
+		{prefix(33, 0, 0, 0, 0), anyAddr, AnyPort, AnyPort, AnyProtocol, 0, nil},
+		{anyAddr, prefix(8, 0, 0, 0), AnyPort, AnyPort, AnyProtocol, 0, nil},
+		{anyAddr, anyAddr, PortRange{2, 1}, AnyPort, AnyProtocol, 0, nil},
+		{anyAddr, anyAddr, AnyPort, AnyPort, 256, 0, nil},
+		{anyAddr, anyAddr, AnyPort, AnyPort, -2, 0, nil},
+	} {
+		if err := a.Add(r); err == nil {
+			t.Errorf("expected add of %+v to fail", r)
+		}
+	}
+	if a.Len() != 0 {
+		t.Errorf("expected no rules, got %d", a.Len())
+	}
+}
+
+// linearMatch is the reference of Match scanning the rules in the order of
+// matching.
+func linearMatch(rules []Rule, p Packet) (interface{}, bool) {
+	covers := func(k lpmtrie.Key, data []byte) bool {
+		for i := 0; i < k.PrefixLen; i++ {
+			if (k.Data[i/8]^data[i/8])&(0x80>>(i%8)) != 0 {
+				return false
+			}
+		}
+		return true
+	}
+	inRange := func(r PortRange, port uint16) bool {
+		return r.First <= port && port <= r.Last
+	}
+
+	for _, r := range rules {
+		if covers(r.Src, p.Src) && covers(r.Dst, p.Dst) &&
+			inRange(r.SrcPorts, p.SrcPort) && inRange(r.DstPorts, p.DstPort) &&
+			(r.Protocol == AnyProtocol || r.Protocol == int(p.Protocol)) {
+			return r.Action, true
+		}
+	}
+	return nil, false
+}
+
+func TestTableRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	addr := func() []byte {
+		return []byte{10, byte(rng.Intn(4)), byte(rng.Intn(4)), byte(rng.Intn(256))}
+	}
+	ports := func() PortRange {
+		first := uint16(rng.Intn(2000))
+		return PortRange{first, first + uint16(rng.Intn(500))}
+	}
+	protocol := func() int {
+		return []int{AnyProtocol, 6, 17}[rng.Intn(3)]
+	}
+
+	a, _ := New(lpmtrie.MaxPrefixLenIPv4)
+	var rules []Rule
+	for i := 0; i < 300; i++ {
+		r := Rule{
+			Src:      lpmtrie.Key{PrefixLen: 8 + rng.Intn(25), Data: addr()},
+			Dst:      lpmtrie.Key{PrefixLen: 8 + rng.Intn(25), Data: addr()},
+			SrcPorts: ports(),
+			DstPorts: ports(),
+			Protocol: protocol(),
+			Priority: rng.Intn(5),
+			Action:   i,
+		}
+		_ = a.Add(r)
+		rules = append(rules, r)
+	}
+	table, _ := a.Compile()
+
+	// the rules in the order of matching
+	ordered := make([]Rule, 0, len(rules))
+	for prio := 4; prio >= 0; prio-- {
+		for _, r := range rules {
+			if r.Priority == prio {
+				ordered = append(ordered, r)
+			}
+		}
+	}
+
+	hits := 0
+	for i := 0; i < 20000; i++ {
+		p := Packet{addr(), addr(), uint16(rng.Intn(2500)), uint16(rng.Intn(2500)), []uint8{6, 17, 1}[rng.Intn(3)]}
+		expect, ok := linearMatch(ordered, p)
+		r, found := table.Match(p)
+		if found != ok || (ok && r.Action != expect) {
+			t.Fatalf("match of %+v: expected %v %v, got %v %v", p, expect, ok, r.Action, found)
+		}
+		if ok {
+			hits++
+		}
+	}
+	if hits == 0 {
+		t.Errorf("expected some packets to match")
+	}
+}
+
+func BenchmarkMatch(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	a, _ := New(lpmtrie.MaxPrefixLenIPv4)
+	for i, key := range lpmtrie.SyntheticTable(1, 1000, lpmtrie.MaxPrefixLenIPv4) {
+		first := uint16(rng.Intn(60000))
+		_ = a.Add(Rule{key, anyAddr, AnyPort, PortRange{first, first + 1000}, 6, 0, i})
+	}
+	table, _ := a.Compile()
+
+	p := Packet{[]byte{10, 0, 0, 1}, []byte{192, 168, 0, 1}, 40000, 443, 6}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		table.Match(p)
+	}
+}
@@ -0,0 +1,164 @@
+package lpmtrie
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	arenaSlabBits = 12
+	arenaSlabSize = 1 << arenaSlabBits
+	arenaSlabMask = arenaSlabSize - 1
+)
+
+// arenaNode is a node with its links to children. The links are indices of
+// nodes in the arena instead of pointers, so that the GC has neither objects
+// per node nor pointers between nodes to scan. They follow the node, so that
+// walking down the trie touches a single place per node.
+type arenaNode struct {
+	lpmTrieNode
+	links [2]atomic.Uint32
+}
+
+// arenaSlab is a slab of nodes. The GC still scans the slabs for the values,
+// and for the key data of the tries wider than 128 bits.
+type arenaSlab struct {
+	nodes [arenaSlabSize]arenaNode
+}
+
+const (
+	arenaReaderBits  = 4
+	arenaReaderSlots = 1 << arenaReaderBits
+)
+
+// readerCount is a count of readers in a cache line of its own, so that the
+// readers counted in different slots don't contend.
+type readerCount struct {
+	n atomic.Int64
+	_ [56]byte
+}
+
+// nodeArena allocates the nodes of a trie created WithArena from slabs, and
+// recycles the nodes removed from the trie by epoch-based reclamation.
+//
+// Readers enter the current epoch before walking the trie, and exit it when
+// done. The nodes removed in an epoch are recycled once the epoch has no
+// readers left and the next epoch has begun, as the readers entering since
+// then cannot reach them. The readers of an epoch are counted in slots picked
+// by their stacks, which spreads the concurrent ones.
+// All the methods but node, links, enter and exit must be called with the
+// writer lock held.
+type nodeArena struct {
+	slabs atomic.Pointer[[]*arenaSlab]
+	root  atomic.Uint32
+	next  uint32 // the next never used index, 0 refers to no node
+	free  []uint32
+
+	epoch   atomic.Uint64
+	readers [3][arenaReaderSlots]readerCount
+	retired [3][]uint32
+}
+
+func newNodeArena() *nodeArena {
+	var a nodeArena
+	a.slabs.Store(&[]*arenaSlab{})
+	a.next = 1
+	return &a
+}
+
+func (a *nodeArena) node(idx uint32) *lpmTrieNode {
+	if idx == 0 {
+		return nil
+	}
+
+	slabs := *a.slabs.Load()
+	return &slabs[idx>>arenaSlabBits].nodes[idx&arenaSlabMask].lpmTrieNode
+}
+
+// links returns the links of n, which must be allocated by the arena.
+func (a *nodeArena) links(n *lpmTrieNode) *[2]atomic.Uint32 {
+	return &(*arenaNode)(unsafe.Pointer(n)).links
+}
+
+func (a *nodeArena) alloc() *lpmTrieNode {
+	var idx uint32
+	if n := len(a.free); n != 0 {
+		idx = a.free[n-1]
+		a.free = a.free[:n-1]
+	} else {
+		idx = a.next
+		a.next++
+
+		slabs := *a.slabs.Load()
+		if int(idx>>arenaSlabBits) == len(slabs) {
+			grown := make([]*arenaSlab, len(slabs)+1)
+			copy(grown, slabs)
+			grown[len(slabs)] = new(arenaSlab)
+			a.slabs.Store(&grown)
+		}
+	}
+
+	n := a.node(idx)
+	links := a.links(n)
+	links[0].Store(0)
+	links[1].Store(0)
+	n.idx = idx
+	n.setIm(false)
+	return n
+}
+
+// retire recycles the node after the readers which may reach it are gone.
+func (a *nodeArena) retire(n *lpmTrieNode) {
+	e := a.epoch.Load()
+	a.retired[e%3] = append(a.retired[e%3], n.idx)
+}
+
+// reclaim begins the next epoch if the previous one has no readers left, and
+// recycles the nodes retired in the previous epoch.
+func (a *nodeArena) reclaim() {
+	e := a.epoch.Load()
+	prev := (e + 2) % 3
+	for i := range a.readers[prev] {
+		if a.readers[prev][i].n.Load() != 0 {
+			return
+		}
+	}
+
+	for _, idx := range a.retired[prev] {
+		n := a.node(idx)
+		n.value.Store(&prunedValue)
+		n.val = nodeValue{} // Note: free the value
+		n.ext = nil
+		a.free = append(a.free, idx)
+	}
+	a.retired[prev] = a.retired[prev][:0]
+
+	a.epoch.Store(e + 1)
+}
+
+// enter returns the epoch entered by the reader, with the slot counting the
+// reader in the low bits.
+func (a *nodeArena) enter() uint64 {
+	slot := readerSlot()
+	for {
+		e := a.epoch.Load()
+		r := &a.readers[e%3][slot].n
+		r.Add(1)
+		if a.epoch.Load() == e {
+			return e<<arenaReaderBits | slot
+		}
+		r.Add(-1)
+	}
+}
+
+func (a *nodeArena) exit(e uint64) {
+	a.readers[(e>>arenaReaderBits)%3][e&(arenaReaderSlots-1)].n.Add(-1)
+}
+
+// readerSlot picks the slot of the reader from the address of its stack, as
+// the stacks of goroutines are apart.
+func readerSlot() uint64 {
+	var b byte
+	h := uint64(uintptr(unsafe.Pointer(&b))>>11) * 0x9e3779b97f4a7c15
+	return h >> (64 - arenaReaderBits)
+}
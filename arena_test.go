@@ -0,0 +1,178 @@
+package lpmtrie
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestArena(t *testing.T) {
+	const plen = 32
+
+	rng := rand.New(rand.NewSource(1))
+
+	lt, _ := New(plen)
+	heap := lt.(*lpmTrie)
+	lt, _ = New(plen, WithArena())
+	arena := lt.(*lpmTrie)
+
+	check := func() {
+		t.Helper()
+
+		if heap.Size() != arena.Size() {
+			t.Fatalf("expected size to be %d, got %d", heap.Size(), arena.Size())
+		}
+
+		var keys []Key
+		heap.Range(func(key Key, val interface{}) bool {
+			keys = append(keys, key)
+			return true
+		})
+		i := 0
+		arena.Range(func(key Key, val interface{}) bool {
+			if i >= len(keys) || key.PrefixLen != keys[i].PrefixLen || !bytes.Equal(key.Data, keys[i].Data) {
+				t.Fatalf("unexpected key %v at %d", key, i)
+			}
+			i++
+			return true
+		})
+		if i != len(keys) {
+			t.Fatalf("expected %d keys, got %d", len(keys), i)
+		}
+
+		for _, key := range randomKeys(rng, 1000, plen) {
+			key.PrefixLen = plen
+			v1, ok1 := heap.Lookup(key)
+			v2, ok2 := arena.Lookup(key)
+			if ok1 != ok2 || v1 != v2 {
+				t.Fatalf("lookup of %v: heap got %v %v, arena got %v %v", key, v1, ok1, v2, ok2)
+			}
+		}
+	}
+
+	keys := randomKeys(rng, 2000, plen)
+	for i, key := range keys {
+		heap.Update(key, i)
+		arena.Update(key, i)
+	}
+	check()
+
+	for _, key := range keys[:1500] {
+		if heap.Delete(key) != arena.Delete(key) {
+			t.Fatalf("delete of %v differs", key)
+		}
+	}
+	check()
+
+	next := arena.arena.next
+	for i, key := range keys[:1500] {
+		heap.Update(key, i)
+		arena.Update(key, i)
+	}
+	check()
+
+	if arena.arena.next-next > 100 {
+		t.Errorf("expected deleted nodes to be recycled, %d more nodes allocated", arena.arena.next-next)
+	}
+}
+
+func TestArenaReclamation(t *testing.T) {
+	const plen = 32
+
+	lt, _ := New(plen, WithArena())
+	trie := lt.(*lpmTrie)
+
+	key := Key{plen, []byte{10, 0, 0, 1}}
+	trie.Update(key, 1)
+	node := trie.rootNode()
+
+	// A reader reaching the node before it is deleted.
+	epoch := trie.enter()
+	trie.Delete(key)
+
+	for i := 0; i < 100; i++ {
+		trie.Update(Key{plen, []byte{10, 0, 1, byte(i)}}, i)
+	}
+	if node.loadValue() != 1 || node.PrefixLen != plen {
+		t.Fatalf("expected node to be kept while the reader may reach it")
+	}
+
+	trie.exit(epoch)
+	for i := 0; i < 4; i++ {
+		trie.Delete(Key{plen, []byte{10, 0, 1, byte(i)}})
+	}
+	if node.loadValue() != nil {
+		t.Errorf("expected node to be recycled after the reader exited")
+	}
+}
+
+func TestArenaConcurrent(t *testing.T) {
+	const plen = 32
+
+	lt, _ := New(plen, WithArena())
+	trie := lt.(*lpmTrie)
+
+	keys := make([]Key, 256)
+	for i := range keys {
+		keys[i] = Key{plen, []byte{10, 0, byte(i), 1}}
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				for i, key := range keys {
+					if v, ok := trie.Lookup(key); ok && v.(int) != i {
+						t.Errorf("expected lookup of %v to be %d, got %v", key, i, v)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for n := 0; n < 50; n++ {
+		for i, key := range keys {
+			trie.Update(key, i)
+		}
+		for _, key := range keys {
+			trie.Delete(key)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	if trie.Size() != 0 {
+		t.Errorf("expected size to be 0, got %d", trie.Size())
+	}
+}
+
+func TestArenaOption(t *testing.T) {
+	if _, err := New(32, WithArena(), WithBackend(BackendStride)); err == nil {
+		t.Errorf("expected arena with stride backend to fail")
+	}
+
+	lt, _ := New(32, WithArena())
+	keys := make([]Key, arenaSlabSize)
+	for i := range keys {
+		keys[i] = Key{32, []byte{10, byte(i >> 8), byte(i), 0}}
+	}
+
+	i := 0
+	if n := testing.AllocsPerRun(100, func() {
+		lt.Update(keys[i], nil)
+		i++
+	}); n != 0 {
+		t.Errorf("expected update of arena not to allocate, got %v", n)
+	}
+}
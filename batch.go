@@ -0,0 +1,90 @@
+package lpmtrie
+
+import "time"
+
+// batchLanes is the number of lookups interleaved by LookupBatch.
+const batchLanes = 8
+
+func (t *lpmTrie) LookupBatch(keys []Key, vals []interface{}, found []bool) {
+	if len(vals) < len(keys) || len(found) < len(keys) {
+		panic("lpmtrie: vals or found is shorter than keys")
+	}
+
+	for _, key := range keys {
+		t.checkKey(key)
+	}
+
+	if t.observer != nil {
+		defer t.observeLookupBatch(time.Now(), found[:len(keys)])
+	}
+
+	t.lookupBatch(keys, vals, found)
+}
+
+func (t *lpmTrie) lookupBatch(keys []Key, vals []interface{}, found []bool) {
+	if t.stride != nil || t.expiring.Load() != 0 {
+		for i, key := range keys {
+			vals[i], found[i] = t.lookup(key)
+		}
+		return
+	}
+
+	defer t.exit(t.enter())
+
+	root := t.rootNode()
+	for i := 0; i < len(keys); i += batchLanes {
+		end := min(i+batchLanes, len(keys))
+		t.lookupLanes(root, keys[i:end], vals[i:end], found[i:end])
+	}
+}
+
+// lookupLanes walks down the trie for up to batchLanes keys in turn, one node
+// per key at a time, so that the memory accesses of different keys overlap.
+func (t *lpmTrie) lookupLanes(root *lpmTrieNode, keys []Key, vals []interface{}, found []bool) {
+	var ks [batchLanes]keyBits
+	var nodes, matched [batchLanes]*lpmTrieNode
+
+	active := 0
+	for i, key := range keys {
+		ks[i] = t.bitsOf(key)
+		nodes[i] = root
+		if root != nil {
+			active++
+		}
+	}
+
+	for active > 0 {
+		for i := range keys {
+			node := nodes[i]
+			if node == nil {
+				continue
+			}
+
+			matchlen := t.longestPrefixMatch(node, ks[i])
+			if matchlen == t.maxPrefixLen {
+				matched[i] = node
+				node = nil
+			} else if matchlen < node.PrefixLen {
+				node = nil
+			} else {
+				if !node.isIm() {
+					matched[i] = node
+				}
+				node = t.childNode(node, ks[i].bit(node.PrefixLen))
+			}
+
+			nodes[i] = node
+			if node == nil {
+				active--
+			}
+		}
+	}
+
+	for i := range keys {
+		if matched[i] == nil {
+			vals[i], found[i] = nil, false
+		} else {
+			vals[i], found[i] = matched[i].loadValue(), true
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package lpmtrie
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestLookupBatch(t *testing.T) {
+	for _, c := range testBackends {
+		t.Run(c.name, func(t *testing.T) {
+			const plen = 32
+
+			rng := rand.New(rand.NewSource(1))
+
+			trie, _ := New(plen, c.opts...)
+			for i, key := range randomKeys(rng, 500, plen) {
+				if key.PrefixLen < 8 {
+					key.PrefixLen = 8
+				}
+				trie.Update(key, i)
+			}
+
+			keys := randomKeys(rng, 1000, plen)
+			for i := range keys {
+				keys[i].PrefixLen = plen
+				if i%10 == 0 {
+					keys[i].Data[0] = 11
+				}
+			}
+			vals := make([]interface{}, len(keys))
+			found := make([]bool, len(keys))
+			for i := range vals {
+				vals[i], found[i] = "stale", true
+			}
+
+			trie.LookupBatch(keys, vals, found)
+
+			hits := 0
+			for i, key := range keys {
+				v, ok := trie.Lookup(key)
+				if ok != found[i] || v != vals[i] {
+					t.Fatalf("lookup of %v: expected %v %v, got %v %v", key, v, ok, vals[i], found[i])
+				}
+				if ok {
+					hits++
+				}
+			}
+			if hits == 0 || hits == len(keys) {
+				t.Errorf("expected both hits and misses, got %d hits", hits)
+			}
+		})
+	}
+}
+
+func TestLookupBatchEmpty(t *testing.T) {
+	trie, _ := New(32)
+
+	keys := []Key{{32, []byte{10, 0, 0, 1}}}
+	vals := []interface{}{1}
+	found := []bool{true}
+	trie.LookupBatch(keys, vals, found)
+	if vals[0] != nil || found[0] {
+		t.Errorf("expected lookup to fail")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected short vals to panic")
+		}
+	}()
+	trie.LookupBatch(keys, nil, found)
+}
+
+func BenchmarkLookupBatch(b *testing.B) {
+	const batch = 64
+
+	for _, f := range benchFamilies {
+		rng := rand.New(rand.NewSource(1))
+		table := SyntheticTable(1, benchTableSize, f.maxPrefixLen)
+		trie := benchTrie(b, table, f.maxPrefixLen)
+		keys := benchHosts(rng, table, 1024, f.maxPrefixLen)
+		vals := make([]interface{}, batch)
+		found := make([]bool, batch)
+
+		b.Run(f.name+"/loop", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				off := i * batch % len(keys)
+				for j, key := range keys[off : off+batch] {
+					vals[j], found[j] = trie.Lookup(key)
+				}
+			}
+		})
+		b.Run(f.name+"/batch", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				off := i * batch % len(keys)
+				trie.LookupBatch(keys[off:off+batch], vals, found)
+			}
+		})
+	}
+}
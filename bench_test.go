@@ -0,0 +1,209 @@
+package lpmtrie
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+const benchTableSize = 100000
+
+var benchFamilies = []struct {
+	name         string
+	maxPrefixLen int
+}{
+	{"IPv4", MaxPrefixLenIPv4},
+	{"IPv6", MaxPrefixLenIPv6},
+}
+
+// benchHosts picks n host keys, each of which is covered by a random route of
+// table.
+func benchHosts(rng *rand.Rand, table []Key, n, maxPrefixLen int) []Key {
+	hosts := make([]Key, n)
+	for i := range hosts {
+		route := table[rng.Intn(len(table))]
+		first, last := route.Bounds()
+		data := make([]byte, maxPrefixLen/8)
+		for j := range data {
+			data[j] = first[j] | byte(rng.Intn(256))&(first[j]^last[j])
+		}
+		hosts[i] = Key{PrefixLen: maxPrefixLen, Data: data}
+	}
+	return hosts
+}
+
+func benchTrie(b *testing.B, table []Key, maxPrefixLen int, opts ...Option) LpmTrie {
+	trie, err := New(maxPrefixLen, opts...)
+	if err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+	for i, key := range table {
+		trie.Update(key, i)
+	}
+	return trie
+}
+
+func BenchmarkLookup(b *testing.B) {
+	for _, f := range benchFamilies {
+		rng := rand.New(rand.NewSource(1))
+		table := SyntheticTable(1, benchTableSize, f.maxPrefixLen)
+		hosts := benchHosts(rng, table, 1024, f.maxPrefixLen)
+
+		for _, c := range testBackends {
+			trie := benchTrie(b, table, f.maxPrefixLen, c.opts...)
+
+			b.Run(f.name+"/"+c.name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					trie.Lookup(hosts[i%len(hosts)])
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkUpdate(b *testing.B) {
+	for _, f := range benchFamilies {
+		table := SyntheticTable(1, benchTableSize, f.maxPrefixLen)
+
+		for _, c := range testBackends {
+			b.Run(f.name+"/"+c.name, func(b *testing.B) {
+				b.ReportAllocs()
+
+				var trie LpmTrie
+				for i := 0; i < b.N; i++ {
+					if i%len(table) == 0 {
+						b.StopTimer()
+						trie, _ = New(f.maxPrefixLen, c.opts...)
+						b.StartTimer()
+					}
+					trie.Update(table[i%len(table)], i)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkDelete(b *testing.B) {
+	for _, f := range benchFamilies {
+		table := SyntheticTable(1, benchTableSize, f.maxPrefixLen)
+
+		for _, c := range testBackends {
+			b.Run(f.name+"/"+c.name, func(b *testing.B) {
+				b.ReportAllocs()
+
+				var trie LpmTrie
+				for i := 0; i < b.N; i++ {
+					if i%len(table) == 0 {
+						b.StopTimer()
+						trie = benchTrie(b, table, f.maxPrefixLen, c.opts...)
+						b.StartTimer()
+					}
+					trie.Delete(table[i%len(table)])
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkRange(b *testing.B) {
+	for _, f := range benchFamilies {
+		table := SyntheticTable(1, benchTableSize, f.maxPrefixLen)
+		trie := benchTrie(b, table, f.maxPrefixLen)
+
+		b.Run(f.name, func(b *testing.B) {
+			b.ReportAllocs()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				trie.Range(func(Key, interface{}) bool {
+					return true
+				})
+			}
+			b.ReportMetric(float64(time.Since(start).Nanoseconds())/float64(b.N*len(table)), "ns/entry")
+		})
+	}
+}
+
+// BenchmarkLookupParallel runs lookups on all the Ps, with or without a
+// writer churning routes in the meanwhile.
+func BenchmarkLookupParallel(b *testing.B) {
+	for _, f := range benchFamilies {
+		rng := rand.New(rand.NewSource(1))
+		table := SyntheticTable(1, benchTableSize+benchTableSize/10, f.maxPrefixLen)
+		table, churn := table[:benchTableSize], table[benchTableSize:]
+		hosts := benchHosts(rng, table, 1024, f.maxPrefixLen)
+
+		for _, c := range testBackends {
+			for _, writers := range []int{0, 1} {
+				trie := benchTrie(b, table, f.maxPrefixLen, c.opts...)
+
+				b.Run(fmt.Sprintf("%s/%s/writers=%d", f.name, c.name, writers), func(b *testing.B) {
+					var wg sync.WaitGroup
+					done := make(chan struct{})
+					for w := 0; w < writers; w++ {
+						wg.Add(1)
+						go func() {
+							defer wg.Done()
+							for {
+								for i, key := range churn {
+									select {
+									case <-done:
+										return
+									default:
+									}
+
+									trie.Update(key, i)
+									trie.Delete(churn[(i+len(churn)/2)%len(churn)])
+								}
+							}
+						}()
+					}
+
+					b.ResetTimer()
+					b.RunParallel(func(pb *testing.PB) {
+						i := rand.Int()
+						for pb.Next() {
+							trie.Lookup(hosts[i%len(hosts)])
+							i++
+						}
+					})
+					b.StopTimer()
+
+					close(done)
+					wg.Wait()
+				})
+			}
+		}
+	}
+}
+
+// BenchmarkMemory reports the heap bytes per entry of tries holding the
+// synthetic tables.
+func BenchmarkMemory(b *testing.B) {
+	for _, f := range benchFamilies {
+		table := SyntheticTable(1, benchTableSize, f.maxPrefixLen)
+
+		for _, c := range testBackends {
+			b.Run(f.name+"/"+c.name, func(b *testing.B) {
+				var total int64
+				for i := 0; i < b.N; i++ {
+					var before, after runtime.MemStats
+					runtime.GC()
+					runtime.ReadMemStats(&before)
+
+					trie := benchTrie(b, table, f.maxPrefixLen, c.opts...)
+
+					runtime.GC()
+					runtime.ReadMemStats(&after)
+					runtime.KeepAlive(trie)
+
+					total += int64(after.HeapAlloc) - int64(before.HeapAlloc)
+				}
+				b.ReportMetric(float64(total)/float64(b.N)/float64(len(table)), "B/entry")
+			})
+		}
+	}
+}
@@ -0,0 +1,287 @@
+// Package classifier classifies packets by rules matching on both the source
+// and the destination prefixes, built on lpmtrie.
+//
+// The rules are kept in hierarchical tries with set-pruning: a source trie
+// holds the source prefixes of the rules, and each of them refers to a
+// destination trie holding the rules of all the source prefixes covering it.
+// So a classification looks up the source trie once, and then the covering
+// prefixes of the destination in one destination trie.
+package classifier
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	"github.com/Asphaltt/lpmtrie"
+)
+
+// Rule matches the packets whose source and destination are covered by Src
+// and Dst respectively.
+type Rule struct {
+	Src, Dst lpmtrie.Key
+
+	// Priority decides the rule of a packet matched by more than one rule,
+	// the higher the better. Among the rules of the same priority, the one
+	// inserted first wins.
+	Priority int
+
+	Value interface{}
+}
+
+// Classifier classifies packets by rules. Rules can be inserted and deleted
+// at runtime. A write copies the dst tries of the source prefixes it affects,
+// which are the source prefix of the rule and the ones under it, and swaps
+// them in. So Classify never blocks and sees the rules either before or after
+// each write, but concurrent Classify calls of other source prefixes may see
+// a write before the others.
+//
+// A write costs the sizes of the dst tries it copies, plus a walk of the
+// source prefixes of the rules.
+type Classifier struct {
+	maxPrefixLen int
+
+	mu    sync.Mutex // serializes writers
+	rules map[ruleID]*rule
+	seq   uint64
+	bySrc lpmtrie.LpmTrie // the rules by their dst by their source prefixes
+
+	// src holds the dst trie of each source prefix, whose values are the
+	// best rules of the destination prefixes. The dst tries are never
+	// modified once stored.
+	src lpmtrie.LpmTrie
+}
+
+// ruleID identifies a rule by its source and destination prefixes.
+type ruleID struct {
+	src, dst string
+}
+
+type rule struct {
+	Rule
+	seq uint64 // the order of insertion
+}
+
+// better returns if r wins over o.
+func (r *rule) better(o *rule) bool {
+	return r.Priority > o.Priority || r.Priority == o.Priority && r.seq < o.seq
+}
+
+// New creates a classifier of addresses of maxPrefixLen bits.
+func New(maxPrefixLen int) (*Classifier, error) {
+	if _, err := lpmtrie.New(maxPrefixLen); err != nil {
+		return nil, err
+	}
+
+	c := Classifier{maxPrefixLen: maxPrefixLen, rules: make(map[ruleID]*rule)}
+	c.bySrc, _ = lpmtrie.New(maxPrefixLen)
+	c.src, _ = lpmtrie.New(maxPrefixLen)
+	return &c, nil
+}
+
+// Len returns the number of rules.
+func (c *Classifier) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.rules)
+}
+
+// Insert inserts the rule, or replaces the rule of the same source and
+// destination prefixes.
+func (c *Classifier) Insert(r Rule) error {
+	if !c.isValidKey(r.Src) || !c.isValidKey(r.Dst) {
+		return errors.New("classifier: invalid prefix of rule")
+	}
+
+	r.Src = canonical(r.Src)
+	r.Dst = canonical(r.Dst)
+	id := idOf(r.Src, r.Dst)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	nr := &rule{Rule: r, seq: c.seq}
+	c.rules[id] = nr
+
+	rules, created := c.srcRules(r.Src)
+	rules[id.dst] = nr
+	if created {
+		c.bySrc.Update(r.Src, rules)
+	}
+	c.refresh(r.Src, r.Dst, id.dst, created)
+	return nil
+}
+
+// Delete deletes the rule of the source and destination prefixes.
+func (c *Classifier) Delete(src, dst lpmtrie.Key) (deleted bool) {
+	if !c.isValidKey(src) || !c.isValidKey(dst) {
+		return false
+	}
+
+	id := idOf(canonical(src), canonical(dst))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.rules[id]
+	if !ok {
+		return false
+	}
+
+	delete(c.rules, id)
+
+	rules, _ := c.srcRules(r.Src)
+	delete(rules, id.dst)
+	if len(rules) == 0 {
+		c.bySrc.Delete(r.Src)
+		c.src.Delete(r.Src)
+	}
+	c.refresh(r.Src, r.Dst, id.dst, false)
+	return true
+}
+
+// Classify returns the best rule matching the packet from src to dst.
+// The lengths of src and dst must be same with eighth of max prefix length
+// rounded up, or it will panic.
+func (c *Classifier) Classify(src, dst []byte) (Rule, bool) {
+	v, ok := c.src.Lookup(lpmtrie.Key{PrefixLen: c.maxPrefixLen, Data: src})
+	if !ok {
+		return Rule{}, false
+	}
+
+	var best *rule
+	v.(lpmtrie.LpmTrie).Supernets(lpmtrie.Key{PrefixLen: c.maxPrefixLen, Data: dst}, func(_ lpmtrie.Key, val interface{}) bool {
+		if r := val.(*rule); best == nil || r.better(best) {
+			best = r
+		}
+		return true
+	})
+	if best == nil {
+		return Rule{}, false
+	}
+
+	return best.Rule, true
+}
+
+// srcRules returns the rules of the source prefix by their dst, creating
+// them if there are none. It must be called with the writer lock held.
+func (c *Classifier) srcRules(src lpmtrie.Key) (rules map[string]*rule, created bool) {
+	if v, ok := c.exactLookup(c.bySrc, src); ok {
+		return v.(map[string]*rule), false
+	}
+	return make(map[string]*rule), true
+}
+
+// refresh updates the dst tries of the source prefixes covered by src after
+// the rule of src and dst changes. The dst trie of src is built from scratch
+// if src is a new source prefix. It must be called with the writer lock held.
+func (c *Classifier) refresh(src, dst lpmtrie.Key, dstID string, created bool) {
+	c.bySrc.Range(func(prefix lpmtrie.Key, _ interface{}) bool {
+		if !covers(src, prefix) {
+			return true
+		}
+
+		if created && prefix.PrefixLen == src.PrefixLen {
+			c.src.Update(prefix, c.build(prefix))
+			return true
+		}
+
+		// Copy on write, Classify may be reading the dst trie.
+		v, _ := c.exactLookup(c.src, prefix)
+		trie := clone(v.(lpmtrie.LpmTrie))
+		if r := c.best(prefix, dstID); r != nil {
+			trie.Update(dst, r)
+		} else {
+			trie.Delete(dst)
+		}
+		c.src.Update(prefix, trie)
+		return true
+	})
+}
+
+// build builds the dst trie of the source prefix. It must be called with the
+// writer lock held.
+func (c *Classifier) build(prefix lpmtrie.Key) lpmtrie.LpmTrie {
+	dst, _ := lpmtrie.New(c.maxPrefixLen)
+
+	// Set-pruning: the dst trie holds the rules of every source prefix
+	// covering prefix, including itself.
+	c.bySrc.Supernets(prefix, func(_ lpmtrie.Key, val interface{}) bool {
+		for _, r := range val.(map[string]*rule) {
+			if v, ok := c.exactLookup(dst, r.Dst); ok && !r.better(v.(*rule)) {
+				continue
+			}
+			dst.Update(r.Dst, r)
+		}
+		return true
+	})
+
+	return dst
+}
+
+// best returns the best rule of the dst among the source prefixes covering
+// prefix, or nil if there is none. It must be called with the writer lock
+// held.
+func (c *Classifier) best(prefix lpmtrie.Key, dstID string) (best *rule) {
+	c.bySrc.Supernets(prefix, func(_ lpmtrie.Key, val interface{}) bool {
+		if r, ok := val.(map[string]*rule)[dstID]; ok && (best == nil || r.better(best)) {
+			best = r
+		}
+		return true
+	})
+	return best
+}
+
+// clone returns a copy of the dst trie.
+func clone(trie lpmtrie.LpmTrie) lpmtrie.LpmTrie {
+	c, _ := lpmtrie.New(trie.MaxPrefixLen())
+	trie.Range(func(key lpmtrie.Key, val interface{}) bool {
+		c.Update(key, val)
+		return true
+	})
+	return c
+}
+
+// exactLookup returns the value of exactly the prefix in trie.
+func (c *Classifier) exactLookup(trie lpmtrie.LpmTrie, prefix lpmtrie.Key) (val interface{}, found bool) {
+	trie.Supernets(prefix, func(key lpmtrie.Key, v interface{}) bool {
+		if key.PrefixLen == prefix.PrefixLen {
+			val, found = v, true
+		}
+		return true
+	})
+	return val, found
+}
+
+func (c *Classifier) isValidKey(key lpmtrie.Key) bool {
+	return 0 <= key.PrefixLen && key.PrefixLen <= c.maxPrefixLen && len(key.Data) == (c.maxPrefixLen+7)/8
+}
+
+// covers returns if the prefix p covers q, both of which are canonical.
+func covers(p, q lpmtrie.Key) bool {
+	if q.PrefixLen < p.PrefixLen {
+		return false
+	}
+
+	n := p.PrefixLen / 8
+	if !bytes.Equal(p.Data[:n], q.Data[:n]) {
+		return false
+	}
+	return p.PrefixLen%8 == 0 || (p.Data[n]^q.Data[n])>>(8-p.PrefixLen%8) == 0
+}
+
+// canonical returns a copy of key with the bits after the prefix length
+// cleared.
+func canonical(key lpmtrie.Key) lpmtrie.Key {
+	first, _ := key.Bounds()
+	return lpmtrie.Key{PrefixLen: key.PrefixLen, Data: first}
+}
+
+func idOf(src, dst lpmtrie.Key) ruleID {
+	return ruleID{
+		src: string(rune(src.PrefixLen)) + string(src.Data),
+		dst: string(rune(dst.PrefixLen)) + string(dst.Data),
+	}
+}
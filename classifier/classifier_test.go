@@ -0,0 +1,233 @@
+package classifier
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/Asphaltt/lpmtrie"
+)
+
+func prefix(plen int, data ...byte) lpmtrie.Key {
+	return lpmtrie.Key{PrefixLen: plen, Data: data}
+}
+
+func TestClassifier(t *testing.T) {
+	c, err := New(lpmtrie.MaxPrefixLenIPv4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rules := []Rule{
+		{prefix(0, 0, 0, 0, 0), prefix(0, 0, 0, 0, 0), 0, "default deny"},
+		{prefix(8, 10, 0, 0, 0), prefix(16, 192, 168, 0, 0), 10, "lan to dmz"},
+		{prefix(24, 10, 1, 1, 0), prefix(24, 192, 168, 1, 0), 20, "admins to db"},
+		{prefix(16, 10, 2, 0, 0), prefix(0, 0, 0, 0, 0), 30, "quarantine"},
+		{prefix(0, 0, 0, 0, 0), prefix(32, 192, 168, 0, 53), 40, "dns"},
+	}
+	for _, r := range rules {
+		if err := c.Insert(r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if c.Len() != len(rules) {
+		t.Errorf("expected %d rules, got %d", len(rules), c.Len())
+	}
+
+	tests := []struct {
+		src, dst []byte
+		expect   string
+	}{
+		{[]byte{10, 0, 0, 1}, []byte{192, 168, 0, 1}, "lan to dmz"},
+		{[]byte{10, 1, 1, 1}, []byte{192, 168, 1, 1}, "admins to db"},
+		{[]byte{10, 1, 1, 1}, []byte{192, 168, 2, 1}, "lan to dmz"},
+		{[]byte{10, 2, 0, 1}, []byte{192, 168, 1, 1}, "quarantine"},
+		{[]byte{10, 2, 0, 1}, []byte{192, 168, 0, 53}, "dns"},
+		{[]byte{11, 0, 0, 1}, []byte{192, 168, 1, 1}, "default deny"},
+	}
+	check := func() {
+		t.Helper()
+
+		for _, tt := range tests {
+			r, ok := c.Classify(tt.src, tt.dst)
+			if !ok || r.Value != tt.expect {
+				t.Errorf("expected %v to %v to be %q, got %v", tt.src, tt.dst, tt.expect, r.Value)
+			}
+		}
+	}
+	check()
+
+	// Replace a rule, with host bits in its prefixes.
+	if err := c.Insert(Rule{prefix(8, 10, 9, 9, 9), prefix(16, 192, 168, 9, 9), 10, "lan to dmz"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Len() != len(rules) {
+		t.Errorf("expected rule to be replaced, got %d rules", c.Len())
+	}
+	check()
+
+	if !c.Delete(prefix(16, 10, 2, 0, 0), prefix(0, 0, 0, 0, 0)) {
+		t.Errorf("expected delete of quarantine to succeed")
+	}
+	if c.Delete(prefix(16, 10, 2, 0, 0), prefix(0, 0, 0, 0, 0)) {
+		t.Errorf("expected delete of absent rule to fail")
+	}
+	if r, _ := c.Classify([]byte{10, 2, 0, 1}, []byte{192, 168, 1, 1}); r.Value != "lan to dmz" {
+		t.Errorf("expected lan to dmz after quarantine is deleted, got %v", r.Value)
+	}
+
+	if err := c.Insert(Rule{Src: prefix(33, 0, 0, 0, 0), Dst: prefix(0, 0, 0, 0, 0)}); err == nil {
+		t.Errorf("expected insert of invalid prefix to fail")
+	}
+	if _, err := New(0); err == nil {
+		t.Errorf("expected classifier of 0 bits to fail")
+	}
+}
+
+func TestClassifierTie(t *testing.T) {
+	c, _ := New(lpmtrie.MaxPrefixLenIPv4)
+	_ = c.Insert(Rule{prefix(8, 10, 0, 0, 0), prefix(0, 0, 0, 0, 0), 1, "first"})
+	_ = c.Insert(Rule{prefix(0, 0, 0, 0, 0), prefix(8, 10, 0, 0, 0), 1, "second"})
+
+	if r, _ := c.Classify([]byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}); r.Value != "first" {
+		t.Errorf("expected the rule inserted first to win, got %v", r.Value)
+	}
+}
+
+// linearClassify is the reference of Classify scanning all the rules.
+func linearClassify(rules []Rule, src, dst []byte) (string, bool) {
+	covers := func(p lpmtrie.Key, data []byte) bool {
+		for i := 0; i < p.PrefixLen; i++ {
+			if (p.Data[i/8]^data[i/8])&(0x80>>(i%8)) != 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	best := -1
+	for i, r := range rules {
+		if covers(r.Src, src) && covers(r.Dst, dst) && (best < 0 || r.Priority > rules[best].Priority) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return "", false
+	}
+	return rules[best].Value.(string), true
+}
+
+func TestClassifierRandom(t *testing.T) {
+	const plen = 16
+
+	rng := rand.New(rand.NewSource(1))
+	randomPrefix := func() lpmtrie.Key {
+		data := []byte{byte(rng.Intn(4)) << 6, byte(rng.Intn(256))}
+		p := lpmtrie.Key{PrefixLen: rng.Intn(plen + 1), Data: data}
+		first, _ := p.Bounds()
+		return lpmtrie.Key{PrefixLen: p.PrefixLen, Data: first}
+	}
+
+	c, _ := New(plen)
+	var rules []Rule
+	seen := make(map[ruleID]bool)
+	for len(rules) < 200 {
+		r := Rule{randomPrefix(), randomPrefix(), len(rules), ""}
+		r.Value = string(rune('A' + len(rules)))
+		if id := idOf(r.Src, r.Dst); !seen[id] {
+			seen[id] = true
+			rules = append(rules, r)
+			_ = c.Insert(r)
+		}
+	}
+
+	for i := 0; i < 10000; i++ {
+		src := []byte{byte(rng.Intn(4)) << 6, byte(rng.Intn(256))}
+		dst := []byte{byte(rng.Intn(4)) << 6, byte(rng.Intn(256))}
+
+		expect, ok := linearClassify(rules, src, dst)
+		r, found := c.Classify(src, dst)
+		if found != ok || (ok && r.Value != expect) {
+			t.Fatalf("classify of %v to %v: expected %q %v, got %v %v", src, dst, expect, ok, r.Value, found)
+		}
+	}
+}
+
+func TestClassifierRandomWrites(t *testing.T) {
+	const plen = 8
+
+	rng := rand.New(rand.NewSource(1))
+	randomPrefix := func() lpmtrie.Key {
+		p := lpmtrie.Key{PrefixLen: rng.Intn(plen + 1), Data: []byte{byte(rng.Intn(256))}}
+		first, _ := p.Bounds()
+		return lpmtrie.Key{PrefixLen: p.PrefixLen, Data: first}
+	}
+
+	c, _ := New(plen)
+	var rules []Rule // in the order of insertion
+	for i := 0; i < 500; i++ {
+		r := Rule{randomPrefix(), randomPrefix(), rng.Intn(4), string(rune('A' + i))}
+		id := idOf(r.Src, r.Dst)
+
+		// Both of inserting and deleting replace the rule of the same
+		// prefixes.
+		for j := range rules {
+			if idOf(rules[j].Src, rules[j].Dst) == id {
+				rules = append(rules[:j], rules[j+1:]...)
+				break
+			}
+		}
+		if rng.Intn(3) == 0 {
+			c.Delete(r.Src, r.Dst)
+		} else {
+			rules = append(rules, r)
+			_ = c.Insert(r)
+		}
+		if c.Len() != len(rules) {
+			t.Fatalf("expected %d rules, got %d", len(rules), c.Len())
+		}
+
+		src, dst := []byte{byte(rng.Intn(256))}, []byte{byte(rng.Intn(256))}
+		expect, ok := linearClassify(rules, src, dst)
+		got, found := c.Classify(src, dst)
+		if found != ok || (ok && got.Value != expect) {
+			t.Fatalf("classify of %v to %v: expected %q %v, got %v %v", src, dst, expect, ok, got.Value, found)
+		}
+	}
+}
+
+func TestClassifierConcurrent(t *testing.T) {
+	c, _ := New(lpmtrie.MaxPrefixLenIPv4)
+	_ = c.Insert(Rule{prefix(0, 0, 0, 0, 0), prefix(0, 0, 0, 0, 0), 0, "default"})
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				if _, ok := c.Classify([]byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}); !ok {
+					t.Errorf("expected the default rule to match")
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 100; i++ {
+		src := prefix(24, 10, 0, byte(i), 0)
+		_ = c.Insert(Rule{src, prefix(0, 0, 0, 0, 0), i + 1, i})
+		if i%2 == 0 {
+			c.Delete(src, prefix(0, 0, 0, 0, 0))
+		}
+	}
+	close(done)
+	wg.Wait()
+}
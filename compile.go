@@ -0,0 +1,188 @@
+package lpmtrie
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+const (
+	dir24Bits = 24
+
+	// dirLongFlag marks an entry of tbl24 as the index of a segment in
+	// tblLong. Otherwise, an entry is the index of the value plus one, or 0
+	// for no match.
+	dirLongFlag = 1 << 31
+)
+
+// CompiledTable is a read-only DIR-24-8 table compiled from an IPv4 trie.
+//
+// The first 24 bits of an address index tbl24 directly. Only when prefixes
+// longer than 24 bits exist under the entry, it refers to a segment of 256
+// entries in tblLong indexed by the last 8 bits.
+// tbl24 always takes 64MiB of memory.
+//
+// Each entry also refers to the longest entry covering it, so that keys
+// shorter than 32 bits fall back to the entries no longer than them.
+type CompiledTable struct {
+	tbl24      []uint32
+	tblLong    []uint32
+	values     []interface{}
+	prefixLens []uint8
+	parents    []uint32 // entries of the longest entries covering each one
+}
+
+func (t *lpmTrie) Compile() (*CompiledTable, error) {
+	if t.maxPrefixLen != MaxPrefixLenIPv4 {
+		return nil, errors.New("lpmtrie: only IPv4 trie can be compiled")
+	}
+
+	var keys []Key
+	var values []interface{}
+
+	t.mu.Lock()
+	t.Range(func(key Key, val interface{}) bool {
+		keys = append(keys, key)
+		values = append(values, val)
+		return true
+	})
+	t.mu.Unlock()
+
+	return compileDIR24(keys, values), nil
+}
+
+func compileDIR24(keys []Key, values []interface{}) *CompiledTable {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	// Paint the shorter prefixes first, so the longer ones overwrite them.
+	sort.SliceStable(order, func(i, j int) bool {
+		return keys[order[i]].PrefixLen < keys[order[j]].PrefixLen
+	})
+
+	c := &CompiledTable{
+		tbl24:      make([]uint32, 1<<dir24Bits),
+		values:     values,
+		prefixLens: make([]uint8, len(keys)),
+		parents:    make([]uint32, len(keys)),
+	}
+
+	for _, i := range order {
+		key := keys[i]
+		addr := binary.BigEndian.Uint32(key.Data)
+		entry := uint32(i + 1)
+
+		// Only the prefixes no longer than key are painted yet, so the
+		// entry of an address of key is the longest one covering key.
+		c.prefixLens[i] = uint8(key.PrefixLen)
+		c.parents[i] = c.entry(addr)
+
+		if key.PrefixLen <= dir24Bits {
+			span := uint32(1) << (dir24Bits - key.PrefixLen)
+			first := addr >> (32 - dir24Bits) &^ (span - 1)
+			fillEntries(c.tbl24[first:first+span], entry)
+			continue
+		}
+
+		i24 := addr >> (32 - dir24Bits)
+		if c.tbl24[i24]&dirLongFlag == 0 {
+			segment := uint32(len(c.tblLong) >> 8)
+			for j := 0; j < 256; j++ {
+				c.tblLong = append(c.tblLong, c.tbl24[i24])
+			}
+			c.tbl24[i24] = dirLongFlag | segment
+		}
+
+		segment := c.tbl24[i24] &^ dirLongFlag
+		span := uint32(1) << (32 - key.PrefixLen)
+		first := segment<<8 | addr&0xff&^(span-1)
+		fillEntries(c.tblLong[first:first+span], entry)
+	}
+
+	return c
+}
+
+func fillEntries(entries []uint32, entry uint32) {
+	for i := range entries {
+		entries[i] = entry
+	}
+}
+
+// entry returns the entry of the longest prefix covering the address.
+func (c *CompiledTable) entry(addr uint32) uint32 {
+	entry := c.tbl24[addr>>(32-dir24Bits)]
+	if entry&dirLongFlag != 0 {
+		entry = c.tblLong[(entry&^dirLongFlag)<<8|addr&0xff]
+	}
+	return entry
+}
+
+// Lookup lookups the value of the key by LPM algo, same as the trie it's
+// compiled from.
+// The key must be an IPv4 key with prefix length between 0 and 32, or it
+// will panic.
+func (c *CompiledTable) Lookup(key Key) (interface{}, bool) {
+	if key.PrefixLen < 0 || key.PrefixLen > MaxPrefixLenIPv4 || len(key.Data) != MaxPrefixLenIPv4/8 {
+		panic("lpmtrie: invalid key")
+	}
+
+	addr := binary.BigEndian.Uint32(key.Data)
+	if key.PrefixLen < MaxPrefixLenIPv4 {
+		addr &^= math.MaxUint32 >> key.PrefixLen
+	}
+
+	entry := c.entry(addr)
+	for entry != 0 && int(c.prefixLens[entry-1]) > key.PrefixLen {
+		entry = c.parents[entry-1]
+	}
+
+	if entry == 0 {
+		return nil, false
+	}
+
+	return c.values[entry-1], true
+}
+
+// AtomicCompiledTable holds a CompiledTable, which can be recompiled and
+// swapped while being looked up.
+// The zero value holds no table, and looks up nothing.
+type AtomicCompiledTable struct {
+	table atomic.Pointer[CompiledTable]
+}
+
+// Load returns the current table.
+func (a *AtomicCompiledTable) Load() *CompiledTable {
+	return a.table.Load()
+}
+
+// Store replaces the current table with c.
+func (a *AtomicCompiledTable) Store(c *CompiledTable) {
+	a.table.Store(c)
+}
+
+// Recompile compiles the trie, and replaces the current table with the
+// result.
+func (a *AtomicCompiledTable) Recompile(trie LpmTrie) error {
+	c, err := trie.Compile()
+	if err != nil {
+		return err
+	}
+
+	a.Store(c)
+	return nil
+}
+
+// Lookup lookups the value of the key in the current table.
+// The key must be an IPv4 key with prefix length between 0 and 32, or it
+// will panic.
+func (a *AtomicCompiledTable) Lookup(key Key) (interface{}, bool) {
+	c := a.Load()
+	if c == nil {
+		return nil, false
+	}
+
+	return c.Lookup(key)
+}
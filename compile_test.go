@@ -0,0 +1,180 @@
+package lpmtrie
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	const plen = 32
+	var trie *lpmTrie
+
+	reset := func() {
+		lt, _ := New(plen)
+		trie = lt.(*lpmTrie)
+	}
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{
+			"not IPv4",
+			func(t *testing.T) {
+				lt, _ := New(MaxPrefixLenIPv6)
+				if _, err := lt.Compile(); err == nil {
+					t.Errorf("expected compile of IPv6 trie to fail")
+				}
+			},
+		},
+		{
+			"empty",
+			func(t *testing.T) {
+				c, err := trie.Compile()
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if _, ok := c.Lookup(Key{plen, []byte{10, 0, 0, 1}}); ok {
+					t.Errorf("expected lookup to fail")
+				}
+			},
+		},
+		{
+			"nested prefixes",
+			func(t *testing.T) {
+				trie.Update(Key{0, []byte{0, 0, 0, 0}}, 0)
+				trie.Update(Key{8, []byte{10, 0, 0, 0}}, 8)
+				trie.Update(Key{24, []byte{10, 1, 1, 0}}, 24)
+				trie.Update(Key{28, []byte{10, 1, 1, 16}}, 28)
+				trie.Update(Key{plen, []byte{10, 1, 1, 17}}, 32)
+				trie.Update(Key{plen, []byte{10, 2, 0, 1}}, 322)
+
+				c, _ := trie.Compile()
+
+				tests := []struct {
+					data   []byte
+					expect int
+				}{
+					{[]byte{11, 0, 0, 0}, 0},
+					{[]byte{10, 200, 0, 0}, 8},
+					{[]byte{10, 1, 1, 0}, 24},
+					{[]byte{10, 1, 1, 16}, 28},
+					{[]byte{10, 1, 1, 17}, 32},
+					{[]byte{10, 1, 1, 32}, 24},
+					{[]byte{10, 2, 0, 1}, 322},
+					{[]byte{10, 2, 0, 2}, 8},
+				}
+				for _, tt := range tests {
+					v, ok := c.Lookup(Key{plen, tt.data})
+					if !ok || v.(int) != tt.expect {
+						t.Errorf("expected lookup of %v to be %d, got %v", tt.data, tt.expect, v)
+					}
+				}
+			},
+		},
+		{
+			"shorter keys",
+			func(t *testing.T) {
+				trie.Update(Key{8, []byte{10, 0, 0, 0}}, 8)
+				trie.Update(Key{24, []byte{10, 1, 1, 0}}, 24)
+				trie.Update(Key{28, []byte{10, 1, 1, 16}}, 28)
+				trie.Update(Key{plen, []byte{10, 1, 1, 17}}, 32)
+
+				c, _ := trie.Compile()
+
+				tests := []struct {
+					key    Key
+					expect int
+				}{
+					{Key{31, []byte{10, 1, 1, 17}}, 28},
+					{Key{28, []byte{10, 1, 1, 31}}, 28},
+					{Key{27, []byte{10, 1, 1, 17}}, 24},
+					{Key{24, []byte{10, 1, 1, 255}}, 24},
+					{Key{16, []byte{10, 1, 1, 17}}, 8},
+					{Key{8, []byte{10, 1, 1, 17}}, 8},
+				}
+				for _, tt := range tests {
+					v, ok := c.Lookup(tt.key)
+					if !ok || v.(int) != tt.expect {
+						t.Errorf("expected lookup of %v to be %d, got %v", tt.key, tt.expect, v)
+					}
+				}
+
+				if _, ok := c.Lookup(Key{7, []byte{10, 1, 1, 17}}); ok {
+					t.Errorf("expected lookup of key shorter than every entry to fail")
+				}
+			},
+		},
+		{
+			"random",
+			func(t *testing.T) {
+				rng := rand.New(rand.NewSource(1))
+				for i, key := range randomKeys(rng, 2000, plen) {
+					trie.Update(key, i)
+				}
+
+				c, _ := trie.Compile()
+				for i, key := range randomKeys(rng, 10000, plen) {
+					if i%2 == 0 {
+						key.PrefixLen = plen
+					}
+					v1, ok1 := trie.Lookup(key)
+					v2, ok2 := c.Lookup(key)
+					if ok1 != ok2 || v1 != v2 {
+						t.Fatalf("lookup of %v: trie got %v %v, compiled got %v %v", key, v1, ok1, v2, ok2)
+					}
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reset()
+			tt.run(t)
+		})
+	}
+}
+
+func TestAtomicCompiledTable(t *testing.T) {
+	const plen = 32
+
+	var table AtomicCompiledTable
+	key := Key{plen, []byte{10, 0, 0, 1}}
+	if _, ok := table.Lookup(key); ok {
+		t.Errorf("expected lookup of empty table to fail")
+	}
+
+	trie, _ := New(plen)
+	trie.Update(Key{8, []byte{10, 0, 0, 0}}, 1)
+	if err := table.Recompile(trie); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	old := table.Load()
+	trie.Update(Key{8, []byte{10, 0, 0, 0}}, 2)
+	if v, _ := table.Lookup(key); v.(int) != 1 {
+		t.Errorf("expected table to be read-only")
+	}
+
+	_ = table.Recompile(trie)
+	if v, _ := table.Lookup(key); v.(int) != 2 {
+		t.Errorf("expected lookup of recompiled table to be 2, got %v", v)
+	}
+	if v, _ := old.Lookup(key); v.(int) != 1 {
+		t.Errorf("expected old table to be unchanged")
+	}
+}
+
+func BenchmarkCompiledLookup(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	table := SyntheticTable(1, benchTableSize, MaxPrefixLenIPv4)
+	trie := benchTrie(b, table, MaxPrefixLenIPv4)
+	c, _ := trie.Compile()
+	hosts := benchHosts(rng, table, 1024, MaxPrefixLenIPv4)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Lookup(hosts[i%len(hosts)])
+	}
+}
@@ -0,0 +1,143 @@
+package lpmtrie
+
+import (
+	"errors"
+	"fmt"
+)
+
+// KeyLayout lays out composite keys of exact-match fields followed by an LPM
+// field, like (VRF id, destination prefix), packed bit by bit in big-endian.
+// An entry of a composite key matches the keys of the same exact fields whose
+// LPM field is covered by its prefix.
+type KeyLayout struct {
+	exactBits []int
+	exactLen  int // the total bits of the exact fields
+	lpmBits   int
+}
+
+// CompositeKey is a key laid out by KeyLayout.
+type CompositeKey struct {
+	// Exact holds the values of the exact-match fields.
+	Exact []uint64
+	// Prefix is the LPM field, whose data is lpmBits/8 bytes rounded up.
+	Prefix Key
+}
+
+// NewKeyLayout creates the layout of keys of the exact-match fields of
+// exactBits bits each, at most 64 bits, followed by an LPM field of lpmBits
+// bits.
+func NewKeyLayout(exactBits []int, lpmBits int) (*KeyLayout, error) {
+	l := KeyLayout{exactBits: append([]int(nil), exactBits...), lpmBits: lpmBits}
+	for _, bits := range exactBits {
+		if bits <= 0 || bits > 64 {
+			return nil, errors.New("lpmtrie: bits of exact field must be between 1 and 64")
+		}
+		l.exactLen += bits
+	}
+	if lpmBits < 0 || l.exactLen+lpmBits == 0 {
+		return nil, errors.New("lpmtrie: bits of LPM field must not be negative, and the key must not be empty")
+	}
+
+	return &l, nil
+}
+
+// MaxPrefixLen returns the max prefix length of the tries of the keys.
+func (l *KeyLayout) MaxPrefixLen() int {
+	return l.exactLen + l.lpmBits
+}
+
+// NewTrie creates a trie of the max prefix length of the layout.
+func (l *KeyLayout) NewTrie(opts ...Option) (LpmTrie, error) {
+	return New(l.MaxPrefixLen(), opts...)
+}
+
+// Check checks that the keys of the layout fit trie.
+func (l *KeyLayout) Check(trie LpmTrie) error {
+	if trie.MaxPrefixLen() != l.MaxPrefixLen() {
+		return fmt.Errorf("lpmtrie: max prefix length of trie is %d, but the key layout takes %d bits",
+			trie.MaxPrefixLen(), l.MaxPrefixLen())
+	}
+	return nil
+}
+
+// Encode lays out ck as a Key, whose prefix length is the total bits of the
+// exact fields plus the prefix length of the LPM field.
+func (l *KeyLayout) Encode(ck CompositeKey) (Key, error) {
+	if len(ck.Exact) != len(l.exactBits) {
+		return Key{}, fmt.Errorf("lpmtrie: expected %d exact fields, got %d", len(l.exactBits), len(ck.Exact))
+	}
+	if len(ck.Prefix.Data) != (l.lpmBits+7)/8 || ck.Prefix.PrefixLen < 0 || ck.Prefix.PrefixLen > l.lpmBits {
+		return Key{}, errors.New("lpmtrie: invalid LPM field")
+	}
+
+	data := make([]byte, (l.MaxPrefixLen()+7)/8)
+	offset := 0
+	for i, bits := range l.exactBits {
+		v := ck.Exact[i]
+		if bits < 64 && v>>bits != 0 {
+			return Key{}, fmt.Errorf("lpmtrie: value %d of exact field %d overflows %d bits", v, i, bits)
+		}
+		for j := 0; j < bits; j++ {
+			setBit(data, offset+j, byte(v>>(bits-1-j))&0x01)
+		}
+		offset += bits
+	}
+
+	for j := 0; j < l.lpmBits; j++ {
+		setBit(data, offset+j, extractBit(ck.Prefix.Data, j))
+	}
+
+	return Key{PrefixLen: l.exactLen + ck.Prefix.PrefixLen, Data: data}, nil
+}
+
+// Decode splits key laid out by Encode into its fields. The key must cover
+// all the exact fields.
+func (l *KeyLayout) Decode(key Key) (CompositeKey, error) {
+	if len(key.Data) != (l.MaxPrefixLen()+7)/8 || key.PrefixLen > l.MaxPrefixLen() {
+		return CompositeKey{}, errors.New("lpmtrie: key doesn't fit the key layout")
+	}
+	if key.PrefixLen < l.exactLen {
+		return CompositeKey{}, errors.New("lpmtrie: key doesn't cover the exact fields")
+	}
+
+	ck := CompositeKey{
+		Exact:  make([]uint64, len(l.exactBits)),
+		Prefix: Key{PrefixLen: key.PrefixLen - l.exactLen, Data: make([]byte, (l.lpmBits+7)/8)},
+	}
+
+	offset := 0
+	for i, bits := range l.exactBits {
+		for j := 0; j < bits; j++ {
+			ck.Exact[i] = ck.Exact[i]<<1 | uint64(extractBit(key.Data, offset+j))
+		}
+		offset += bits
+	}
+
+	for j := 0; j < l.lpmBits; j++ {
+		setBit(ck.Prefix.Data, j, extractBit(key.Data, offset+j))
+	}
+
+	return ck, nil
+}
+
+// Range iterates over the entries of trie like LpmTrie.Range, with their
+// keys decoded. The entries whose keys don't cover the exact fields are
+// skipped.
+func (l *KeyLayout) Range(trie LpmTrie, fn func(key CompositeKey, val interface{}) bool) {
+	trie.Range(func(key Key, val interface{}) bool {
+		ck, err := l.Decode(key)
+		if err != nil {
+			return true
+		}
+		return fn(ck, val)
+	})
+}
+
+func setBit(data []byte, index int, bit byte) {
+	mask := byte(0x80) >> (index % 8)
+	if bit != 0 {
+		data[index/8] |= mask
+	} else {
+		data[index/8] &^= mask
+	}
+}
@@ -0,0 +1,120 @@
+package lpmtrie
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKeyLayout(t *testing.T) {
+	// (VRF id of 12 bits, address family of 1 bit, IPv4 destination)
+	layout, err := NewKeyLayout([]int{12, 1}, MaxPrefixLenIPv4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if layout.MaxPrefixLen() != 45 {
+		t.Errorf("expected max prefix length to be 45, got %d", layout.MaxPrefixLen())
+	}
+
+	trie, _ := layout.NewTrie()
+	if err := layout.Check(trie); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	other, _ := New(MaxPrefixLenIPv4)
+	if err := layout.Check(other); err == nil {
+		t.Errorf("expected check of IPv4 trie to fail")
+	}
+
+	encode := func(vrf uint64, plen int, data ...byte) Key {
+		t.Helper()
+
+		key, err := layout.Encode(CompositeKey{[]uint64{vrf, 1}, Key{plen, data}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return key
+	}
+
+	trie.Update(encode(1, 8, 10, 0, 0, 0), "vrf1 10/8")
+	trie.Update(encode(1, 16, 10, 1, 0, 0), "vrf1 10.1/16")
+	trie.Update(encode(0xfff, 0, 0, 0, 0, 0), "vrf4095 default")
+
+	tests := []struct {
+		key    Key
+		expect interface{}
+	}{
+		{encode(1, 32, 10, 1, 2, 3), "vrf1 10.1/16"},
+		{encode(1, 32, 10, 2, 2, 3), "vrf1 10/8"},
+		{encode(2, 32, 10, 1, 2, 3), nil},
+		{encode(0xfff, 32, 10, 1, 2, 3), "vrf4095 default"},
+		{encode(0xffe, 32, 10, 1, 2, 3), nil},
+	}
+	for _, tt := range tests {
+		if v, _ := trie.Lookup(tt.key); v != tt.expect {
+			t.Errorf("expected lookup of %v to be %v, got %v", tt.key, tt.expect, v)
+		}
+	}
+
+	var keys []CompositeKey
+	layout.Range(trie, func(key CompositeKey, val interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	expect := []CompositeKey{
+		{[]uint64{1, 1}, Key{16, []byte{10, 1, 0, 0}}},
+		{[]uint64{1, 1}, Key{8, []byte{10, 0, 0, 0}}},
+		{[]uint64{0xfff, 1}, Key{0, []byte{0, 0, 0, 0}}},
+	}
+	if len(keys) != len(expect) {
+		t.Fatalf("expected %d keys, got %v", len(expect), keys)
+	}
+	for i, key := range keys {
+		if key.Exact[0] != expect[i].Exact[0] || key.Exact[1] != expect[i].Exact[1] ||
+			key.Prefix.PrefixLen != expect[i].Prefix.PrefixLen || !bytes.Equal(key.Prefix.Data, expect[i].Prefix.Data) {
+			t.Errorf("expected key %v, got %v", expect[i], key)
+		}
+	}
+}
+
+func TestKeyLayoutErrors(t *testing.T) {
+	for _, tt := range []struct {
+		exactBits []int
+		lpmBits   int
+	}{
+		{[]int{0}, 32},
+		{[]int{65}, 32},
+		{[]int{16}, -1},
+		{nil, 0},
+	} {
+		if _, err := NewKeyLayout(tt.exactBits, tt.lpmBits); err == nil {
+			t.Errorf("expected layout %v, %d to fail", tt.exactBits, tt.lpmBits)
+		}
+	}
+
+	layout, _ := NewKeyLayout([]int{4, 64}, 16)
+	for _, ck := range []CompositeKey{
+		{[]uint64{1}, Key{16, []byte{0, 0}}},
+		{[]uint64{16, 0}, Key{16, []byte{0, 0}}},
+		{[]uint64{1, 0}, Key{17, []byte{0, 0}}},
+		{[]uint64{1, 0}, Key{16, []byte{0, 0, 0}}},
+	} {
+		if _, err := layout.Encode(ck); err == nil {
+			t.Errorf("expected encode of %v to fail", ck)
+		}
+	}
+
+	key, err := layout.Encode(CompositeKey{[]uint64{15, 1<<64 - 1}, Key{4, []byte{0xa0, 0}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ck, err := layout.Decode(key)
+	if err != nil || ck.Exact[0] != 15 || ck.Exact[1] != 1<<64-1 || ck.Prefix.PrefixLen != 4 || ck.Prefix.Data[0] != 0xa0 {
+		t.Errorf("expected key to be decoded back, got %v, %v", ck, err)
+	}
+
+	if _, err := layout.Decode(Key{67, key.Data}); err == nil {
+		t.Errorf("expected decode of key not covering exact fields to fail")
+	}
+	if _, err := layout.Decode(Key{68, key.Data[1:]}); err == nil {
+		t.Errorf("expected decode of short key to fail")
+	}
+}
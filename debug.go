@@ -0,0 +1,126 @@
+package lpmtrie
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+)
+
+// Dump writes the node structure of the trie as an ASCII tree, one node per
+// line, in which each child is labeled with its bit at the prefix length of
+// its parent, and the intermediate nodes are marked. It's for debugging, and
+// may be inconsistent while being written concurrently.
+//
+//	10.0.0.0/8 = 8
+//	└─0─ 10.0.0.0/14 (intermediate)
+//	     ├─0─ 10.1.0.0/16 = 1
+//	     └─1─ 10.2.0.0/16 = 2
+func (t *lpmTrie) Dump(w io.Writer) error {
+	defer t.exit(t.enter())
+
+	bw := bufio.NewWriter(w)
+	if root := t.rootNode(); root == nil {
+		bw.WriteString("(empty)\n")
+	} else {
+		t.dump(bw, root, "", "")
+	}
+	return bw.Flush()
+}
+
+// dump writes the node after head, and the lines of its children after
+// indent.
+func (t *lpmTrie) dump(w *bufio.Writer, node *lpmTrieNode, head, indent string) {
+	fmt.Fprintf(w, "%s%s\n", head, t.describeNode(node))
+
+	last := byte(1)
+	if t.childNode(node, 1) == nil {
+		last = 0
+	}
+
+	for bit := byte(0); bit < 2; bit++ {
+		child := t.childNode(node, bit)
+		if child == nil {
+			continue
+		}
+
+		if bit == last {
+			t.dump(w, child, fmt.Sprintf("%s└─%d─ ", indent, bit), indent+"     ")
+		} else {
+			t.dump(w, child, fmt.Sprintf("%s├─%d─ ", indent, bit), indent+"│    ")
+		}
+	}
+}
+
+func (t *lpmTrie) String() string {
+	var sb strings.Builder
+	_ = t.Dump(&sb)
+	return sb.String()
+}
+
+// WriteDOT writes the node structure of the trie as a Graphviz DOT digraph,
+// in which the intermediate nodes are dashed and the edges are labeled with
+// the child bits. It's for debugging, and may be inconsistent while being
+// written concurrently.
+func (t *lpmTrie) WriteDOT(w io.Writer) error {
+	defer t.exit(t.enter())
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("digraph lpmtrie {\n\tnode [shape=box];\n")
+	if root := t.rootNode(); root != nil {
+		id := 0
+		t.writeDOT(bw, root, &id)
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+// writeDOT writes the node as n<id> and its subtree, and advances id past
+// the subtree.
+func (t *lpmTrie) writeDOT(w *bufio.Writer, node *lpmTrieNode, id *int) {
+	self := *id
+	*id++
+
+	if node.isIm() {
+		fmt.Fprintf(w, "\tn%d [label=%q, style=dashed];\n", self, t.keyString(&node.keyBits))
+	} else {
+		label := fmt.Sprintf("%s\n%v", t.keyString(&node.keyBits), node.loadValue())
+		fmt.Fprintf(w, "\tn%d [label=%q];\n", self, label)
+	}
+
+	for bit := byte(0); bit < 2; bit++ {
+		if child := t.childNode(node, bit); child != nil {
+			fmt.Fprintf(w, "\tn%d -> n%d [label=\"%d\"];\n", self, *id, bit)
+			t.writeDOT(w, child, id)
+		}
+	}
+}
+
+func (t *lpmTrie) describeNode(node *lpmTrieNode) string {
+	if node.isIm() {
+		return t.keyString(&node.keyBits) + " (intermediate)"
+	}
+	return fmt.Sprintf("%s = %v", t.keyString(&node.keyBits), node.loadValue())
+}
+
+// keyString formats k as an IP prefix for IPv4 and IPv6 tries, or as the
+// hex of the data with the prefix length. The host bits are cleared, as the
+// intermediate nodes keep the ones of the key they are created for.
+func (t *lpmTrie) keyString(k *keyBits) string {
+	key := t.keyOf(k)
+	clearHostBits(key.Data, k.PrefixLen)
+
+	var s string
+	switch t.maxPrefixLen {
+	case MaxPrefixLenIPv4:
+		s = netip.AddrFrom4(*(*[4]byte)(key.Data)).String()
+	case MaxPrefixLenIPv6:
+		s = netip.AddrFrom16(*(*[16]byte)(key.Data)).String()
+	default:
+		s = hex.EncodeToString(key.Data)
+	}
+
+	return fmt.Sprintf("%s/%d", s, k.PrefixLen)
+}
@@ -0,0 +1,98 @@
+package lpmtrie
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	const plen = 32
+
+	trie, _ := New(plen)
+	if s := trie.String(); s != "(empty)\n" {
+		t.Errorf("unexpected dump of empty trie %q", s)
+	}
+
+	trie.Update(Key{16, []byte{10, 1, 0, 0}}, 1)
+	trie.Update(Key{16, []byte{10, 2, 0, 0}}, 2)
+	trie.Update(Key{8, []byte{10, 0, 0, 0}}, 8)
+	trie.Update(Key{plen, []byte{10, 2, 0, 1}}, 32)
+	trie.Update(Key{plen, []byte{192, 168, 0, 1}}, 192)
+
+	expect := `0.0.0.0/0 (intermediate)
+├─0─ 10.0.0.0/8 = 8
+│    └─0─ 10.0.0.0/14 (intermediate)
+│         ├─0─ 10.1.0.0/16 = 1
+│         └─1─ 10.2.0.0/16 = 2
+│              └─0─ 10.2.0.1/32 = 32
+└─1─ 192.168.0.1/32 = 192
+`
+	if s := trie.String(); s != expect {
+		t.Errorf("expected dump:\n%s\ngot:\n%s", expect, s)
+	}
+
+	var buf bytes.Buffer
+	if err := trie.Dump(&buf); err != nil || buf.String() != expect {
+		t.Errorf("expected Dump to write the same as String, got %v", err)
+	}
+}
+
+func TestDumpKeyFormat(t *testing.T) {
+	trie, _ := New(MaxPrefixLenIPv6)
+	trie.Update(Key{32, []byte{0x20, 0x01, 0x0d, 0xb8, 15: 0}}, 1)
+	if s := trie.String(); s != "2001:db8::/32 = 1\n" {
+		t.Errorf("unexpected dump %q", s)
+	}
+
+	trie, _ = New(24)
+	trie.Update(Key{12, []byte{0xab, 0xc0, 0}}, 1)
+	if s := trie.String(); s != "abc000/12 = 1\n" {
+		t.Errorf("unexpected dump %q", s)
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	const plen = 32
+
+	trie, _ := New(plen, WithArena())
+	trie.Update(Key{16, []byte{10, 1, 0, 0}}, 1)
+	trie.Update(Key{16, []byte{10, 2, 0, 0}}, 2)
+
+	var buf bytes.Buffer
+	if err := trie.WriteDOT(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expect := `digraph lpmtrie {
+	node [shape=box];
+	n0 [label="10.0.0.0/14", style=dashed];
+	n0 -> n1 [label="0"];
+	n1 [label="10.1.0.0/16\n1"];
+	n0 -> n2 [label="1"];
+	n2 [label="10.2.0.0/16\n2"];
+}
+`
+	if buf.String() != expect {
+		t.Errorf("expected DOT:\n%s\ngot:\n%s", expect, buf.String())
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestDumpWriteError(t *testing.T) {
+	trie, _ := New(32)
+	trie.Update(Key{8, []byte{10, 0, 0, 0}}, strings.Repeat("x", 8192))
+
+	if err := trie.Dump(failingWriter{}); err == nil {
+		t.Errorf("expected Dump to return the write error")
+	}
+	if err := trie.WriteDOT(failingWriter{}); err == nil {
+		t.Errorf("expected WriteDOT to return the write error")
+	}
+}
@@ -0,0 +1,25 @@
+package lpmtrie
+
+// defaultKey returns the key of the default route, whose prefix length is 0.
+func (t *lpmTrie) defaultKey() Key {
+	return Key{PrefixLen: 0, Data: make([]byte, t.keySize)}
+}
+
+func (t *lpmTrie) SetDefault(val interface{}) (updated bool) {
+	return t.Update(t.defaultKey(), val)
+}
+
+func (t *lpmTrie) Default() (interface{}, bool) {
+	defer t.exit(t.enter())
+
+	// The default route covers all the keys, so it's always the root.
+	root := t.rootNode()
+	if root == nil || root.PrefixLen != 0 || root.isIm() || t.expired(root) {
+		return nil, false
+	}
+	return root.loadValue(), true
+}
+
+func (t *lpmTrie) ClearDefault() (deleted bool) {
+	return t.Delete(t.defaultKey())
+}
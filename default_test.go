@@ -0,0 +1,89 @@
+package lpmtrie
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestDefault(t *testing.T) {
+	for _, plen := range []int{8, 12, 16, 20, 32, 48, 64, 128, 130, 256} {
+		for _, c := range testBackends {
+			t.Run(fmt.Sprintf("%d/%s", plen, c.name), func(t *testing.T) {
+				rng := rand.New(rand.NewSource(int64(plen)))
+				host := func(first byte) Key {
+					data := make([]byte, (plen+7)/8)
+					rng.Read(data)
+					data[0] = first
+					return Key{plen, data}
+				}
+				prefix := func(first byte) Key {
+					data := make([]byte, (plen+7)/8)
+					data[0] = first
+					return Key{8, data}
+				}
+
+				trie, _ := New(plen, c.opts...)
+				if _, ok := trie.Default(); ok {
+					t.Errorf("expected no default route")
+				}
+				if trie.ClearDefault() {
+					t.Errorf("expected clear of absent default route to fail")
+				}
+
+				if trie.SetDefault("default") {
+					t.Errorf("expected default route to be inserted")
+				}
+				if v, ok := trie.Default(); !ok || v != "default" {
+					t.Errorf("expected default route, got %v", v)
+				}
+				if v, ok := trie.Lookup(host(0x80)); !ok || v != "default" {
+					t.Errorf("expected lookup to fall back to default route, got %v", v)
+				}
+
+				// Split the trie at the first bit below the default route,
+				// and make the root intermediate once it's cleared.
+				trie.Update(prefix(0x00), "low")
+				trie.Update(prefix(0x80), "high")
+				if v, _ := trie.Lookup(host(0x80)); v != "high" {
+					t.Errorf("expected more specific entry to match, got %v", v)
+				}
+				if v, _ := trie.Lookup(host(0x40)); v != "default" {
+					t.Errorf("expected lookup to fall back to default route, got %v", v)
+				}
+
+				if !trie.SetDefault("new") || trie.Size() != 3 {
+					t.Errorf("expected default route to be replaced")
+				}
+				if !trie.ClearDefault() {
+					t.Errorf("expected default route to be cleared")
+				}
+				if _, ok := trie.Default(); ok {
+					t.Errorf("expected no default route")
+				}
+				if _, ok := trie.Lookup(host(0x40)); ok {
+					t.Errorf("expected lookup to fail without default route")
+				}
+				if v, _ := trie.Lookup(host(0x00)); v != "low" || trie.Size() != 2 {
+					t.Errorf("expected entries to be kept, got %v", v)
+				}
+
+				// Turn the intermediate root back into the default route,
+				// with a key of prefix length 0 with data.
+				if trie.Update(Key{0, host(0xff).Data}, "any") {
+					t.Errorf("expected default route to be inserted")
+				}
+				if v, ok := trie.Default(); !ok || v != "any" || trie.Size() != 3 {
+					t.Errorf("expected default route, got %v", v)
+				}
+				if v, _ := trie.Lookup(host(0x40)); v != "any" {
+					t.Errorf("expected lookup to fall back to default route, got %v", v)
+				}
+
+				if err := trie.Validate(); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package lpmtrie
+
+import (
+	"bytes"
+	"testing"
+)
+
+// refEntry is an entry of refTable.
+type refEntry struct {
+	prefixLen int
+	data      []byte // with the host bits cleared
+	val       int
+}
+
+// refTable is a naive LPM table scanning all the entries, as the reference
+// of the trie in FuzzLpmTrie.
+type refTable struct {
+	entries []refEntry
+}
+
+func (r *refTable) find(key Key) int {
+	data := append([]byte(nil), key.Data...)
+	clearHostBits(data, key.PrefixLen)
+	for i, e := range r.entries {
+		if e.prefixLen == key.PrefixLen && bytes.Equal(e.data, data) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *refTable) update(key Key, val int) bool {
+	if i := r.find(key); i >= 0 {
+		r.entries[i].val = val
+		return true
+	}
+
+	data := append([]byte(nil), key.Data...)
+	clearHostBits(data, key.PrefixLen)
+	r.entries = append(r.entries, refEntry{key.PrefixLen, data, val})
+	return false
+}
+
+func (r *refTable) delete(key Key) bool {
+	i := r.find(key)
+	if i < 0 {
+		return false
+	}
+	r.entries = append(r.entries[:i], r.entries[i+1:]...)
+	return true
+}
+
+func (r *refTable) lookup(key Key) (int, bool) {
+	best := -1
+	for i, e := range r.entries {
+		if e.prefixLen <= key.PrefixLen &&
+			commonPrefixLen(e.data, key.Data, e.prefixLen) == e.prefixLen &&
+			(best < 0 || e.prefixLen > r.entries[best].prefixLen) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return 0, false
+	}
+	return r.entries[best].val, true
+}
+
+// FuzzLpmTrie decodes the input into operations on a trie and a refTable,
+// and checks that they agree. The first byte selects the width of keys, and
+// each operation takes an opcode byte, a prefix length byte and the key data.
+func FuzzLpmTrie(f *testing.F) {
+	f.Add([]byte{2, 0, 24, 10, 1, 1, 0, 2, 32, 10, 1, 1, 1})
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		if len(in) == 0 {
+			return
+		}
+
+		plen := []int{8, 16, 32, 128, 12, 20}[int(in[0])%6]
+		in = in[1:]
+
+		trie, _ := New(plen)
+		var ref refTable
+
+		opSize := 2 + (plen+7)/8
+		for i := 0; len(in) >= opSize; i, in = i+1, in[opSize:] {
+			key := Key{
+				PrefixLen: int(in[1]) % (plen + 1),
+				Data:      append([]byte(nil), in[2:opSize]...),
+			}
+
+			switch in[0] % 3 {
+			case 0:
+				if got, expect := trie.Update(key, i), ref.update(key, i); got != expect {
+					t.Fatalf("update of %v: expected updated to be %v", key, expect)
+				}
+			case 1:
+				if got, expect := trie.Delete(key), ref.delete(key); got != expect {
+					t.Fatalf("delete of %v: expected deleted to be %v", key, expect)
+				}
+			default:
+				expect, ok := ref.lookup(key)
+				if v, found := trie.Lookup(key); found != ok || (ok && v.(int) != expect) {
+					t.Fatalf("lookup of %v: expected %v %v, got %v %v", key, expect, ok, v, found)
+				}
+			}
+
+			if trie.Size() != int64(len(ref.entries)) {
+				t.Fatalf("expected size to be %d, got %d", len(ref.entries), trie.Size())
+			}
+		}
+
+		n := 0
+		trie.Range(func(key Key, val interface{}) bool {
+			i := ref.find(key)
+			if i < 0 || ref.entries[i].val != val.(int) {
+				t.Fatalf("unexpected entry %v: %v", key, val)
+			}
+			n++
+			return true
+		})
+		if n != len(ref.entries) {
+			t.Fatalf("expected %d entries, got %d", len(ref.entries), n)
+		}
+
+		if err := trie.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package lpmtrie
+
+import "errors"
+
+// ErrPoolExhausted is returned by Allocate when there is no free block of the
+// requested size in the pool.
+var ErrPoolExhausted = errors.New("lpmtrie: pool exhausted")
+
+func (t *lpmTrie) Gaps(within Key, fn func(key Key) bool) {
+	t.checkKey(within)
+
+	block := Key{PrefixLen: within.PrefixLen, Data: make([]byte, t.keySize)}
+	copy(block.Data, within.Data)
+	clearHostBits(block.Data, block.PrefixLen)
+
+	defer t.exit(t.enter())
+
+	_ = t.gaps(t.rootNode(), block, fn)
+}
+
+// gaps reports the uncovered parts of block, where node is the root of the
+// subtree holding every entry inside block.
+func (t *lpmTrie) gaps(node *lpmTrieNode, block Key, fn func(key Key) bool) (terminated bool) {
+	k := t.bitsOf(block)
+	for ; node != nil && node.PrefixLen <= block.PrefixLen; node = t.childNode(node, k.bit(node.PrefixLen)) {
+		if t.longestPrefixMatch(node, k) < node.PrefixLen {
+			return !fn(block)
+		}
+
+		if !node.isIm() {
+			return false // covered by node
+		}
+
+		if node.PrefixLen == block.PrefixLen {
+			lo, hi := splitBlock(block)
+			if t.gaps(t.childNode(node, 0), lo, fn) {
+				return true
+			}
+			return t.gaps(t.childNode(node, 1), hi, fn)
+		}
+	}
+
+	if node == nil || t.longestPrefixMatch(node, k) < block.PrefixLen {
+		return !fn(block)
+	}
+
+	// node is inside block, so the half of block without node is free.
+	lo, hi := splitBlock(block)
+	if node.bit(block.PrefixLen) == 0 {
+		if t.gaps(node, lo, fn) {
+			return true
+		}
+		return !fn(hi)
+	}
+
+	if !fn(lo) {
+		return true
+	}
+	return t.gaps(node, hi, fn)
+}
+
+// splitBlock splits block into its lower and upper halves.
+func splitBlock(block Key) (lo, hi Key) {
+	lo = Key{PrefixLen: block.PrefixLen + 1, Data: make([]byte, len(block.Data))}
+	copy(lo.Data, block.Data)
+
+	hi = Key{PrefixLen: block.PrefixLen + 1, Data: make([]byte, len(block.Data))}
+	copy(hi.Data, block.Data)
+	hi.Data[block.PrefixLen/8] |= 0x80 >> (block.PrefixLen % 8)
+	return lo, hi
+}
+
+func (t *lpmTrie) FindFree(within Key, prefixLen int) (Key, bool) {
+	t.checkKey(within)
+
+	if prefixLen < within.PrefixLen || prefixLen > t.maxPrefixLen {
+		return Key{}, false
+	}
+
+	var free Key
+	var found bool
+	t.Gaps(within, func(gap Key) bool {
+		if gap.PrefixLen > prefixLen {
+			return true
+		}
+
+		free = Key{PrefixLen: prefixLen, Data: gap.Data}
+		found = true
+		return false
+	})
+
+	return free, found
+}
+
+func (t *lpmTrie) Allocate(pool Key, prefixLen int, val interface{}) (Key, error) {
+	t.checkKey(pool)
+
+	if prefixLen < pool.PrefixLen || prefixLen > t.maxPrefixLen {
+		return Key{}, errors.New("lpmtrie: prefixLen must be between pool's prefix length and max prefix length")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key, ok := t.FindFree(pool, prefixLen)
+	if !ok {
+		return Key{}, ErrPoolExhausted
+	}
+
+	t.update(key, val)
+	return key, nil
+}
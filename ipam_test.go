@@ -0,0 +1,299 @@
+package lpmtrie
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func collectGaps(trie *lpmTrie, within Key) []Key {
+	var gaps []Key
+	trie.Gaps(within, func(key Key) bool {
+		gaps = append(gaps, key)
+		return true
+	})
+	return gaps
+}
+
+func expectKeys(t *testing.T, keys, expect []Key) {
+	t.Helper()
+
+	if len(keys) != len(expect) {
+		t.Fatalf("expected %v, got %v", expect, keys)
+	}
+	for i := range keys {
+		if keys[i].PrefixLen != expect[i].PrefixLen || !bytes.Equal(keys[i].Data, expect[i].Data) {
+			t.Errorf("expected key %d to be %v, got %v", i, expect[i], keys[i])
+		}
+	}
+}
+
+func TestGaps(t *testing.T) {
+	const plen = 32
+	var trie *lpmTrie
+
+	reset := func() {
+		lt, _ := New(plen)
+		trie = lt.(*lpmTrie)
+	}
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{
+			"empty",
+			func(t *testing.T) {
+				gaps := collectGaps(trie, Key{8, []byte{10, 1, 2, 3}})
+				expectKeys(t, gaps, []Key{{8, []byte{10, 0, 0, 0}}})
+			},
+		},
+		{
+			"covered",
+			func(t *testing.T) {
+				trie.Update(Key{8, []byte{10, 0, 0, 0}}, 1)
+
+				gaps := collectGaps(trie, Key{16, []byte{10, 1, 0, 0}})
+				expectKeys(t, gaps, nil)
+			},
+		},
+		{
+			"outside",
+			func(t *testing.T) {
+				trie.Update(Key{16, []byte{10, 2, 0, 0}}, 1)
+
+				gaps := collectGaps(trie, Key{16, []byte{10, 1, 0, 0}})
+				expectKeys(t, gaps, []Key{{16, []byte{10, 1, 0, 0}}})
+			},
+		},
+		{
+			"one entry",
+			func(t *testing.T) {
+				trie.Update(Key{10, []byte{10, 64, 0, 0}}, 1)
+
+				gaps := collectGaps(trie, Key{8, []byte{10, 0, 0, 0}})
+				expectKeys(t, gaps, []Key{
+					{10, []byte{10, 0, 0, 0}},
+					{9, []byte{10, 128, 0, 0}},
+				})
+			},
+		},
+		{
+			"intermediate node",
+			func(t *testing.T) {
+				trie.Update(Key{24, []byte{10, 0, 0, 0}}, 1)
+				trie.Update(Key{24, []byte{10, 0, 1, 0}}, 2)
+
+				rt := loadPointer(&trie.root)
+				if !rt.isIm() || rt.PrefixLen != 23 {
+					t.Fatalf("expected root to be intermediate")
+				}
+
+				gaps := collectGaps(trie, Key{22, []byte{10, 0, 0, 0}})
+				expectKeys(t, gaps, []Key{{23, []byte{10, 0, 2, 0}}})
+
+				gaps = collectGaps(trie, Key{23, []byte{10, 0, 0, 0}})
+				expectKeys(t, gaps, nil)
+			},
+		},
+		{
+			"terminated",
+			func(t *testing.T) {
+				trie.Update(Key{24, []byte{10, 0, 1, 0}}, 1)
+
+				var gaps []Key
+				trie.Gaps(Key{16, []byte{10, 0, 0, 0}}, func(key Key) bool {
+					gaps = append(gaps, key)
+					return false
+				})
+				expectKeys(t, gaps, []Key{{24, []byte{10, 0, 0, 0}}})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reset()
+			tt.run(t)
+		})
+	}
+}
+
+func TestFindFree(t *testing.T) {
+	const plen = 32
+	var trie *lpmTrie
+
+	reset := func() {
+		lt, _ := New(plen)
+		trie = lt.(*lpmTrie)
+	}
+
+	pool := Key{16, []byte{10, 0, 0, 0}}
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{
+			"empty",
+			func(t *testing.T) {
+				key, ok := trie.FindFree(pool, 24)
+				if !ok {
+					t.Fatalf("expected free block")
+				}
+				expectKeys(t, []Key{key}, []Key{{24, []byte{10, 0, 0, 0}}})
+			},
+		},
+		{
+			"lowest free block",
+			func(t *testing.T) {
+				trie.Update(Key{24, []byte{10, 0, 0, 0}}, 1)
+				trie.Update(Key{24, []byte{10, 0, 2, 0}}, 2)
+
+				key, ok := trie.FindFree(pool, 24)
+				if !ok {
+					t.Fatalf("expected free block")
+				}
+				expectKeys(t, []Key{key}, []Key{{24, []byte{10, 0, 1, 0}}})
+
+				key, ok = trie.FindFree(pool, 23)
+				if !ok {
+					t.Fatalf("expected free block")
+				}
+				expectKeys(t, []Key{key}, []Key{{23, []byte{10, 0, 4, 0}}})
+			},
+		},
+		{
+			"exhausted",
+			func(t *testing.T) {
+				trie.Update(Key{17, []byte{10, 0, 0, 0}}, 1)
+				trie.Update(Key{18, []byte{10, 0, 128, 0}}, 2)
+				trie.Update(Key{18, []byte{10, 0, 192, 0}}, 3)
+
+				if key, ok := trie.FindFree(pool, 24); ok {
+					t.Errorf("expected no free block, got %v", key)
+				}
+			},
+		},
+		{
+			"invalid prefix length",
+			func(t *testing.T) {
+				if _, ok := trie.FindFree(pool, 8); ok {
+					t.Errorf("expected no free block shorter than pool")
+				}
+				if _, ok := trie.FindFree(pool, plen+1); ok {
+					t.Errorf("expected no free block longer than max prefix length")
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reset()
+			tt.run(t)
+		})
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	const plen = 32
+	var trie *lpmTrie
+
+	reset := func() {
+		lt, _ := New(plen)
+		trie = lt.(*lpmTrie)
+	}
+
+	pool := Key{22, []byte{10, 0, 0, 0}}
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{
+			"allocate in order",
+			func(t *testing.T) {
+				trie.Update(Key{24, []byte{10, 0, 1, 0}}, 0)
+
+				var keys []Key
+				for i := 0; i < 3; i++ {
+					key, err := trie.Allocate(pool, 24, i+1)
+					if err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					keys = append(keys, key)
+				}
+				expectKeys(t, keys, []Key{
+					{24, []byte{10, 0, 0, 0}},
+					{24, []byte{10, 0, 2, 0}},
+					{24, []byte{10, 0, 3, 0}},
+				})
+
+				v, ok := trie.Lookup(Key{plen, []byte{10, 0, 2, 1}})
+				if !ok || v.(int) != 2 {
+					t.Errorf("expected lookup to succeed")
+				}
+
+				if _, err := trie.Allocate(pool, 24, 4); err != ErrPoolExhausted {
+					t.Errorf("expected ErrPoolExhausted, got %v", err)
+				}
+				if trie.Size() != 4 {
+					t.Errorf("expected size to be 4")
+				}
+			},
+		},
+		{
+			"invalid prefix length",
+			func(t *testing.T) {
+				if _, err := trie.Allocate(pool, 16, 1); err == nil || err == ErrPoolExhausted {
+					t.Errorf("expected invalid prefix length error, got %v", err)
+				}
+			},
+		},
+		{
+			"concurrent",
+			func(t *testing.T) {
+				const n = 64
+
+				var wg sync.WaitGroup
+				keys := make([]Key, n)
+				errs := make([]error, n)
+				for i := 0; i < n; i++ {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						keys[i], errs[i] = trie.Allocate(pool, 28, i)
+					}(i)
+				}
+				wg.Wait()
+
+				seen := make(map[string]bool)
+				for i := 0; i < n; i++ {
+					if errs[i] != nil {
+						t.Fatalf("unexpected error: %v", errs[i])
+					}
+					s := string(keys[i].Data)
+					if seen[s] {
+						t.Errorf("block %v allocated twice", keys[i])
+					}
+					seen[s] = true
+				}
+
+				if trie.Size() != n {
+					t.Errorf("expected size to be %d, got %d", n, trie.Size())
+				}
+				if _, err := trie.Allocate(pool, 28, n); err != ErrPoolExhausted {
+					t.Errorf("expected ErrPoolExhausted, got %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reset()
+			tt.run(t)
+		})
+	}
+}
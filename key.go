@@ -0,0 +1,174 @@
+package lpmtrie
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"unsafe"
+)
+
+// wordsSize is the number of key bytes packed into the words of keyBits.
+const wordsSize = 16
+
+// keyBits is the fixed-size form of a key used inside the trie.
+//
+// The first 128 bits of the key data are packed big-endian into two machine
+// words, so that matching the keys of IPv4 and IPv6 tries takes a XOR and a
+// LeadingZeros per word, without the slice header of Key.Data. Only the keys
+// wider than 128 bits keep the rest of the data in ext.
+type keyBits struct {
+	PrefixLen int
+	hi, lo    uint64
+	ext       *byte // the data after the first wordsSize bytes
+}
+
+// bitsOf converts key to keyBits. The ext of the result refers to the data
+// of key.
+func (t *lpmTrie) bitsOf(key Key) keyBits {
+	be := binary.BigEndian
+	k := keyBits{PrefixLen: key.PrefixLen}
+
+	switch t.keySize {
+	case 4:
+		k.hi = uint64(be.Uint32(key.Data)) << 32
+	case 8:
+		k.hi = be.Uint64(key.Data)
+	case 16:
+		k.hi, k.lo = be.Uint64(key.Data), be.Uint64(key.Data[8:])
+	default:
+		var buf [wordsSize]byte
+		copy(buf[:], key.Data)
+		k.hi, k.lo = be.Uint64(buf[:8]), be.Uint64(buf[8:])
+		if t.keySize > wordsSize {
+			k.ext = &key.Data[wordsSize]
+		}
+	}
+
+	return k
+}
+
+// nodeBitsOf converts key to keyBits owning a copy of the data of key. The
+// bits after the max prefix length are cleared, so that they don't show up
+// in the keys of the entries.
+func (t *lpmTrie) nodeBitsOf(key Key) keyBits {
+	k := t.bitsOf(key)
+	if k.ext != nil {
+		ext := make([]byte, t.keySize-wordsSize)
+		copy(ext, key.Data[wordsSize:])
+		clearHostBits(ext, t.maxPrefixLen-wordsSize*8)
+		k.ext = &ext[0]
+	} else {
+		k.hi &= prefixMask(t.maxPrefixLen)
+		k.lo &= prefixMask(t.maxPrefixLen - 64)
+	}
+	return k
+}
+
+// truncateBits returns k cut to prefixLen bits, with the bits after cleared.
+// The data after the first wordsSize bytes is dropped if the prefix doesn't
+// reach it, or copied otherwise.
+func (t *lpmTrie) truncateBits(k keyBits, prefixLen int) keyBits {
+	k.PrefixLen = prefixLen
+	k.hi &= prefixMask(prefixLen)
+	k.lo &= prefixMask(prefixLen - 64)
+	if k.ext != nil {
+		if prefixLen <= wordsSize*8 {
+			k.ext = nil
+		} else {
+			ext := make([]byte, t.keySize-wordsSize)
+			copy(ext, t.extOf(&k))
+			clearHostBits(ext, prefixLen-wordsSize*8)
+			k.ext = &ext[0]
+		}
+	}
+	return k
+}
+
+// sameBits returns if a and b have the same bits up to the max prefix
+// length. A nil ext counts as zero bits.
+func (t *lpmTrie) sameBits(a, b *keyBits) bool {
+	if (a.hi^b.hi)&prefixMask(t.maxPrefixLen) != 0 ||
+		(a.lo^b.lo)&prefixMask(t.maxPrefixLen-64) != 0 {
+		return false
+	}
+
+	for i := wordsSize * 8; i < t.maxPrefixLen; i += 8 {
+		var x, y byte
+		if a.ext != nil {
+			x = *(*byte)(unsafe.Add(unsafe.Pointer(a.ext), i/8-wordsSize))
+		}
+		if b.ext != nil {
+			y = *(*byte)(unsafe.Add(unsafe.Pointer(b.ext), i/8-wordsSize))
+		}
+		if n := t.maxPrefixLen - i; n < 8 {
+			x, y = x>>(8-n), y>>(8-n)
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
+
+// prefixMask returns the mask of the first prefixLen bits of a word.
+func prefixMask(prefixLen int) uint64 {
+	switch {
+	case prefixLen <= 0:
+		return 0
+	case prefixLen >= 64:
+		return ^uint64(0)
+	}
+	return ^uint64(0) << (64 - prefixLen)
+}
+
+// keyOf converts k back to Key.
+func (t *lpmTrie) keyOf(k *keyBits) Key {
+	var buf [wordsSize]byte
+	binary.BigEndian.PutUint64(buf[:8], k.hi)
+	binary.BigEndian.PutUint64(buf[8:], k.lo)
+
+	data := make([]byte, t.keySize)
+	n := copy(data, buf[:])
+	if k.ext != nil {
+		copy(data[n:], t.extOf(k))
+	}
+
+	return Key{PrefixLen: k.PrefixLen, Data: data}
+}
+
+func (t *lpmTrie) extOf(k *keyBits) []byte {
+	return unsafe.Slice(k.ext, t.keySize-wordsSize)
+}
+
+// bit returns the bit of k at index.
+func (k *keyBits) bit(index int) byte {
+	switch {
+	case index < 64:
+		return byte(k.hi>>(63-index)) & 0x01
+	case index < 128:
+		return byte(k.lo>>(127-index)) & 0x01
+	}
+
+	index -= 128
+	b := *(*byte)(unsafe.Add(unsafe.Pointer(k.ext), index/8))
+	return (b >> (7 - (index % 8))) & 0x01
+}
+
+func (t *lpmTrie) longestPrefixMatch(node *lpmTrieNode, key keyBits) int {
+	limit := min(node.PrefixLen, key.PrefixLen)
+
+	if diff := node.hi ^ key.hi; diff != 0 {
+		return min(bits.LeadingZeros64(diff), limit)
+	}
+	if limit <= 64 {
+		return limit
+	}
+
+	if diff := node.lo ^ key.lo; diff != 0 {
+		return min(64+bits.LeadingZeros64(diff), limit)
+	}
+	if limit <= 128 {
+		return limit
+	}
+
+	return 128 + commonPrefixLen(t.extOf(&node.keyBits), t.extOf(&key), limit-128)
+}
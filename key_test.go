@@ -0,0 +1,74 @@
+package lpmtrie
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+	"unsafe"
+)
+
+func TestKeyBits(t *testing.T) {
+	for _, plen := range []int{8, 24, 32, 64, 128, 136, 256} {
+		t.Run(fmt.Sprintf("max prefix length %d", plen), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(int64(plen)))
+
+			lt, _ := New(plen)
+			trie := lt.(*lpmTrie)
+
+			for i := 0; i < 100; i++ {
+				key1 := Key{PrefixLen: rng.Intn(plen + 1), Data: make([]byte, plen/8)}
+				rng.Read(key1.Data)
+
+				k1 := trie.nodeBitsOf(key1)
+				if key := trie.keyOf(&k1); key.PrefixLen != key1.PrefixLen || !bytes.Equal(key.Data, key1.Data) {
+					t.Fatalf("expected key %v, got %v", key1, key)
+				}
+
+				for index := 0; index < plen; index++ {
+					if b := k1.bit(index); b != extractBit(key1.Data, index) {
+						t.Fatalf("expected bit %d of %v to be %d", index, key1.Data, extractBit(key1.Data, index))
+					}
+				}
+
+				// key2 shares a random number of leading bits with key1.
+				shared := rng.Intn(plen + 1)
+				key2 := Key{PrefixLen: plen, Data: make([]byte, plen/8)}
+				copy(key2.Data, key1.Data)
+				if shared < plen {
+					key2.Data[shared/8] ^= 0x80 >> (shared % 8)
+				}
+
+				node := newLpmTrieNode(k1, nil)
+				expect := min(shared, key1.PrefixLen)
+				if matchlen := trie.longestPrefixMatch(node, trie.bitsOf(key2)); matchlen != expect {
+					t.Fatalf("expected longest prefix match of %v and %v to be %d, got %d", key1, key2, expect, matchlen)
+				}
+			}
+		})
+	}
+}
+
+func TestNodeBitsOfOwnsData(t *testing.T) {
+	const plen = 256
+
+	lt, _ := New(plen)
+	key := Key{PrefixLen: plen, Data: make([]byte, plen/8)}
+	lt.Update(key, 1)
+
+	key.Data[plen/8-1] = 1
+	lt.Range(func(k Key, val interface{}) bool {
+		if k.Data[plen/8-1] != 0 {
+			t.Errorf("expected the trie not to refer to data of updated key")
+		}
+		return true
+	})
+}
+
+func TestNodeSize(t *testing.T) {
+	// A node takes the 80-byte size class, while the key data of IPv4 and
+	// IPv6 tries takes no more memory.
+	if size := unsafe.Sizeof(lpmTrieNode{}); size > 80 {
+		t.Errorf("expected node to be at most 80 bytes, got %d", size)
+	}
+}
@@ -0,0 +1,35 @@
+package lpmtrie
+
+import "time"
+
+func (t *lpmTrie) LoadOrStore(key Key, val interface{}) (actual interface{}, loaded bool) {
+	t.checkKey(key)
+
+	if t.observer != nil {
+		defer t.observeStore(time.Now(), &loaded)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if node := t.entryNode(key); node != nil {
+		return node.loadValue(), true
+	}
+
+	t.update(key, val)
+	return val, false
+}
+
+func (t *lpmTrie) LoadAndDelete(key Key) (val interface{}, loaded bool) {
+	t.checkKey(key)
+
+	if t.observer != nil {
+		defer t.observeDelete(time.Now(), &loaded)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.purge(key)
+	return t.delete(key)
+}
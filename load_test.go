@@ -0,0 +1,122 @@
+package lpmtrie
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestLoadOrStore(t *testing.T) {
+	const plen = 32
+
+	for _, opts := range [][]Option{nil, {WithBackend(BackendStride)}, {WithArena()}} {
+		var o countingObserver
+		trie, _ := New(plen, append(opts, WithObserver(&o))...)
+		key := Key{16, []byte{10, 1, 0, 0}}
+
+		if v, loaded := trie.LoadOrStore(key, 1); loaded || v != 1 {
+			t.Errorf("expected value 1 to be stored, got %v", v)
+		}
+		if v, loaded := trie.LoadOrStore(Key{16, []byte{10, 1, 255, 255}}, 2); !loaded || v != 1 {
+			t.Errorf("expected value 1 to be loaded, got %v", v)
+		}
+
+		// A covering entry doesn't match exactly.
+		if v, loaded := trie.LoadOrStore(Key{24, []byte{10, 1, 1, 0}}, 3); loaded || v != 3 {
+			t.Errorf("expected value 3 to be stored, got %v", v)
+		}
+		if v, _ := trie.Lookup(Key{plen, []byte{10, 1, 1, 1}}); v != 3 {
+			t.Errorf("expected value 3, got %v", v)
+		}
+		if trie.Size() != 2 {
+			t.Errorf("expected size 2, got %d", trie.Size())
+		}
+
+		if o.updates != [2]int{2, 0} {
+			t.Errorf("expected 2 inserts, got %v", o.updates)
+		}
+	}
+}
+
+func TestLoadAndDelete(t *testing.T) {
+	const plen = 32
+
+	for _, opts := range [][]Option{nil, {WithBackend(BackendStride)}, {WithArena()}} {
+		var o countingObserver
+		trie, _ := New(plen, append(opts, WithObserver(&o))...)
+
+		trie.Update(Key{8, []byte{10, 0, 0, 0}}, 1)
+		trie.Update(Key{16, []byte{10, 1, 0, 0}}, 2)
+		trie.Update(Key{16, []byte{10, 2, 0, 0}}, 3)
+		trie.Update(Key{24, []byte{10, 1, 1, 0}}, 4)
+
+		if _, loaded := trie.LoadAndDelete(Key{24, []byte{10, 1, 2, 0}}); loaded {
+			t.Errorf("expected delete of absent key to fail")
+		}
+		if v, loaded := trie.LoadAndDelete(Key{24, []byte{10, 1, 1, 0}}); !loaded || v != 4 {
+			t.Errorf("expected value 4 to be deleted, got %v", v)
+		}
+		// node with two children
+		if v, loaded := trie.LoadAndDelete(Key{8, []byte{10, 0, 0, 0}}); !loaded || v != 1 {
+			t.Errorf("expected value 1 to be deleted, got %v", v)
+		}
+		if _, loaded := trie.LoadAndDelete(Key{8, []byte{10, 0, 0, 0}}); loaded {
+			t.Errorf("expected delete of intermediate node to fail")
+		}
+		if v, loaded := trie.LoadAndDelete(Key{16, []byte{10, 2, 0, 0}}); !loaded || v != 3 {
+			t.Errorf("expected value 3 to be deleted, got %v", v)
+		}
+
+		if v, _ := trie.Lookup(Key{plen, []byte{10, 1, 1, 1}}); v != 2 {
+			t.Errorf("expected value 2, got %v", v)
+		}
+		if trie.Size() != 1 {
+			t.Errorf("expected size 1, got %d", trie.Size())
+		}
+		if err := trie.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		if o.deletes != [2]int{2, 3} {
+			t.Errorf("expected 2 misses and 3 hits, got %v", o.deletes)
+		}
+	}
+}
+
+func TestLoadOrStoreConcurrent(t *testing.T) {
+	const plen = 32
+	const workers = 8
+
+	trie, _ := New(plen)
+	key := Key{24, []byte{10, 1, 1, 0}}
+
+	var wg sync.WaitGroup
+	stored := make([]int, workers)
+	deleted := make([]int, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if _, loaded := trie.LoadOrStore(key, i); !loaded {
+					stored[i]++
+				}
+				if _, loaded := trie.LoadAndDelete(key); loaded {
+					deleted[i]++
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var s, d int
+	for i := range stored {
+		s += stored[i]
+		d += deleted[i]
+	}
+	if s != d {
+		t.Errorf("expected every store to be deleted once, got %d stores and %d deletes", s, d)
+	}
+	if trie.Size() != 0 {
+		t.Errorf("expected empty trie, got size %d", trie.Size())
+	}
+}
@@ -3,10 +3,11 @@ package lpmtrie
 // inspired by: https://github.com/torvalds/linux/blob/master/kernel/bpf/lpm_trie.c
 
 import (
-	"encoding/binary"
 	"errors"
-	"math/bits"
+	"io"
+	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
@@ -18,105 +19,455 @@ const (
 // Key is the key of the trie.
 // A key is a byte array with a prefix length.
 // The prefix length is the number of bits for the key.
-// The length of the byte array must be same with eighth of trie's max prefix length.
+// The length of the byte array must be same with eighth of trie's max prefix length
+// rounded up, and the bits of the last byte after the max prefix length are ignored.
 type Key struct {
 	PrefixLen int
 	Data      []byte
 }
 
 // LpmTrie is a trie data structure which implements Longest Prefix Match algorithm.
+// Writers are serialized by an internal lock, while readers never block.
 type LpmTrie interface {
 	// Size returns the number of entries in the trie.
 	Size() int64
 
+	// MaxPrefixLen returns the max prefix length of the trie, which is the
+	// bit width of its keys.
+	MaxPrefixLen() int
+
 	// Lookup lookups the value of the key by LPM algo.
-	// The length of key's data must be same with eighth of trie's max prefix length,
-	// or it will panic.
+	// The length of key's data must be same with eighth of trie's max prefix length
+	// rounded up, or it will panic.
 	Lookup(key Key) (interface{}, bool)
 
+	// LookupBatch lookups the values of the keys by LPM algo, and stores
+	// them into vals and found in the same order. Lookups of the keys are
+	// interleaved, which costs less than calling Lookup for each key.
+	// The lengths of vals and found must not be less than the length of keys,
+	// and the length of each key's data must be same with eighth of trie's
+	// max prefix length rounded up, or it will panic.
+	LookupBatch(keys []Key, vals []interface{}, found []bool)
+
 	// Update updates the value of the key by LPM algo.
 	// If the key is not found, it inserts the key-value pair.
-	// The length of key's data must be same with eighth of trie's max prefix length,
-	// or it will panic.
+	// It returns true only if the key was in the trie and its value is replaced.
+	// The length of key's data must be same with eighth of trie's max prefix length
+	// rounded up, or it will panic.
 	Update(key Key, val interface{}) (updated bool)
 
 	// Delete deletes the key-value pair by LPM algo.
-	// The length of key's data must be same with eighth of trie's max prefix length,
-	// or it will panic.
+	// The length of key's data must be same with eighth of trie's max prefix length
+	// rounded up, or it will panic.
 	Delete(key Key) (deleted bool)
 
+	// UpdateWithTTL updates the value of the key like Update, and makes the
+	// entry expire after ttl, which must be positive. An expired entry is
+	// invisible to Lookup, Range and the other reads at once, and is
+	// deleted by the next writer of the key, by a Lookup matching it if no
+	// writer holds the lock, or by Sweep. Until then it's still counted by
+	// Size, and still covers its addresses for HasSubnets, Gaps, FindFree
+	// and Allocate.
+	// Update makes the entry permanent again, while Upsert and
+	// CompareAndSwap keep its expiry.
+	// The length of key's data must be same with eighth of trie's max prefix length
+	// rounded up, or it will panic.
+	UpdateWithTTL(key Key, val interface{}, ttl time.Duration) (updated bool)
+
+	// Sweep deletes the expired entries, and returns the number of them.
+	Sweep() (deleted int)
+
+	// Upsert sets the value of exactly the key to the one returned by fn,
+	// which is called with the current value and whether the key is in the
+	// trie. The value of an existing entry is replaced in place, so Upsert
+	// is atomic with respect to the other writers, and readers see either
+	// the old or the new value.
+	// fn is called with the writer lock held, so it must not modify the trie.
+	// The length of key's data must be same with eighth of trie's max prefix length
+	// rounded up, or it will panic.
+	Upsert(key Key, fn func(old interface{}, exists bool) interface{}) (updated bool)
+
+	// CompareAndSwap replaces the value of exactly the key with new if the
+	// key is in the trie and its value is equal to old. The old value must
+	// be comparable, or it may panic.
+	// The length of key's data must be same with eighth of trie's max prefix length
+	// rounded up, or it will panic.
+	CompareAndSwap(key Key, old, new interface{}) (swapped bool)
+
+	// LoadOrStore returns the value of exactly the key if the key is in the
+	// trie. Otherwise, it inserts the key with the value val and returns val.
+	// The loaded result is true if the value was loaded, false if stored.
+	// The length of key's data must be same with eighth of trie's max prefix length
+	// rounded up, or it will panic.
+	LoadOrStore(key Key, val interface{}) (actual interface{}, loaded bool)
+
+	// LoadAndDelete deletes exactly the key, and returns its previous value
+	// if any. The loaded result reports whether the key was in the trie.
+	// The length of key's data must be same with eighth of trie's max prefix length
+	// rounded up, or it will panic.
+	LoadAndDelete(key Key) (val interface{}, loaded bool)
+
+	// SetDefault sets the value of the default route, the key of prefix
+	// length 0, to which Lookup falls back if no other entry matches.
+	// It's same as updating a key of prefix length 0 with any data.
+	SetDefault(val interface{}) (updated bool)
+
+	// Default returns the value of the default route.
+	Default() (interface{}, bool)
+
+	// ClearDefault deletes the default route. The more specific entries are
+	// kept.
+	ClearDefault() (deleted bool)
+
 	// Range iterates over the key-value pairs in the trie by in-order.
 	Range(fn func(key Key, val interface{}) bool)
+
+	// InsertRange inserts the inclusive address range [start, end] as the
+	// minimal set of prefixes covering it, each with the value val.
+	// The length of start and end must be same with eighth of trie's max
+	// prefix length rounded up, or it will panic.
+	InsertRange(start, end []byte, val interface{}) error
+
+	// Gaps iterates over the largest aligned blocks inside within that are
+	// not covered by any entry in the trie, in ascending order.
+	// The length of within's data must be same with eighth of trie's max
+	// prefix length rounded up, or it will panic.
+	Gaps(within Key, fn func(key Key) bool)
+
+	// FindFree returns the lowest aligned block of prefixLen bits inside
+	// within that is not covered by any entry in the trie.
+	// The length of within's data must be same with eighth of trie's max
+	// prefix length rounded up, or it will panic.
+	FindFree(within Key, prefixLen int) (Key, bool)
+
+	// Allocate inserts the lowest free aligned block of prefixLen bits
+	// inside pool with the value val, and returns the block.
+	// It returns ErrPoolExhausted if there is no free block.
+	// The length of pool's data must be same with eighth of trie's max
+	// prefix length rounded up, or it will panic.
+	Allocate(pool Key, prefixLen int, val interface{}) (Key, error)
+
+	// Supernets iterates over the key-value pairs whose prefix covers the
+	// prefix, including the prefix itself, from the least specific one.
+	// The length of prefix's data must be same with eighth of trie's max
+	// prefix length rounded up, or it will panic.
+	Supernets(prefix Key, fn func(key Key, val interface{}) bool)
+
+	// HasSubnets returns if there is any entry more specific than the prefix.
+	// The length of prefix's data must be same with eighth of trie's max
+	// prefix length rounded up, or it will panic.
+	HasSubnets(prefix Key) bool
+
+	// Compile freezes the entries of an IPv4 trie into a read-only DIR-24-8
+	// table, which looks up an address with at most two memory accesses.
+	Compile() (*CompiledTable, error)
+
+	// Stats walks the trie and returns the statistics of its structure.
+	// Writers are blocked during the walk.
+	Stats() Stats
+
+	// Dump writes the node structure of the trie as an ASCII tree, including
+	// the intermediate nodes, for debugging.
+	Dump(w io.Writer) error
+
+	// String returns the node structure of the trie written by Dump.
+	String() string
+
+	// WriteDOT writes the node structure of the trie as a Graphviz DOT
+	// digraph, including the intermediate nodes, for debugging.
+	WriteDOT(w io.Writer) error
+
+	// Validate checks the invariants of the structure of the trie, and
+	// returns an error describing the first broken one.
+	Validate() error
 }
 
 type nodeValue struct {
-	v interface{}
+	v interface{} // *expiringValue for the entries updated with a TTL
 }
 
 var prunedValue = nodeValue{}
 
 type lpmTrieNode struct {
-	Key
-	child [2]unsafe.Pointer // *lpmTrieNode
-	value atomic.Value
-	im    atomic.Value
+	keyBits
+	child [2]atomic.Pointer[lpmTrieNode]
+	value atomic.Pointer[nodeValue]
+	val   nodeValue // inline storage of the value the node is created with
+	flags atomic.Uint32
+	idx   uint32 // index in the arena, only for tries created WithArena
 }
 
+const (
+	nodeIm     = 1 << iota // intermediate node
+	nodeFirst              // node allocated together with the next one
+	nodeSecond             // node allocated together with the previous one
+)
+
 var nilNode = (*lpmTrieNode)(nil)
 
-func newLpmTrieNode(key Key, value interface{}) *lpmTrieNode {
+func newLpmTrieNode(key keyBits, value interface{}) *lpmTrieNode {
 	var n lpmTrieNode
-	n.Key = key
-	n.storeValue(nodeValue{v: value})
-	storePointer(&n.child[0], nilNode)
-	storePointer(&n.child[1], nilNode)
-	n.im.Store(false)
+	n.init(key, value)
 	return &n
 }
 
-func (n *lpmTrieNode) storeValue(v nodeValue) {
+// newLpmTrieNodeWithIm allocates the node and the intermediate node above it
+// at once. Once either of them is removed from the trie, the other one is
+// moved to a node of its own by retire, so that the removed one and what it
+// refers to can be freed.
+func newLpmTrieNodeWithIm(key keyBits, value interface{}, imKey keyBits) (node, imNode *lpmTrieNode) {
+	nodes := new([2]lpmTrieNode)
+	node, imNode = &nodes[0], &nodes[1]
+	node.init(key, value)
+	node.flags.Store(nodeFirst)
+	imNode.init(imKey, nil)
+	imNode.flags.Store(nodeSecond | nodeIm)
+	return node, imNode
+}
+
+// partner returns the node allocated together with n by
+// newLpmTrieNodeWithIm, or nil.
+func (n *lpmTrieNode) partner() *lpmTrieNode {
+	switch f := n.flags.Load(); {
+	case f&nodeFirst != 0:
+		return (*lpmTrieNode)(unsafe.Add(unsafe.Pointer(n), unsafe.Sizeof(*n)))
+	case f&nodeSecond != 0:
+		return (*lpmTrieNode)(unsafe.Add(unsafe.Pointer(n), -int(unsafe.Sizeof(*n))))
+	}
+	return nil
+}
+
+func (n *lpmTrieNode) init(key keyBits, value interface{}) {
+	n.keyBits = key
+	n.val.v = value
+	n.value.Store(&n.val)
+}
+
+func (n *lpmTrieNode) storeValue(v *nodeValue) {
 	n.value.Store(v)
 }
 
 func (n *lpmTrieNode) loadValue() interface{} {
-	return n.value.Load().(nodeValue).v
+	v := n.value.Load().v
+	if e, ok := v.(*expiringValue); ok {
+		return e.v
+	}
+	return v
 }
 
 // isIm returns if the node is intermediate one.
 func (n *lpmTrieNode) isIm() bool {
-	return n.im.Load().(bool)
+	return n.flags.Load()&nodeIm != 0
 }
 
+// setIm must be called by the writers only.
 func (n *lpmTrieNode) setIm(v bool) {
-	n.im.Store(v)
+	f := n.flags.Load()
+	if v {
+		f |= nodeIm
+	} else {
+		f &^= nodeIm
+	}
+	n.flags.Store(f)
+}
+
+func loadPointer(ptr *atomic.Pointer[lpmTrieNode]) *lpmTrieNode {
+	return ptr.Load()
+}
+
+func storePointer(ptr *atomic.Pointer[lpmTrieNode], node *lpmTrieNode) {
+	ptr.Store(node)
+}
+
+// nodeSlot refers to the root of the trie if parent is nil, or to the child
+// of parent at bit.
+type nodeSlot struct {
+	parent *lpmTrieNode
+	bit    byte
+}
+
+func (t *lpmTrie) rootNode() *lpmTrieNode {
+	if t.arena != nil {
+		return t.arena.node(t.arena.root.Load())
+	}
+	return loadPointer(&t.root)
+}
+
+func (t *lpmTrie) childNode(n *lpmTrieNode, bit byte) *lpmTrieNode {
+	if t.arena != nil {
+		return t.arena.node(t.arena.links(n)[bit].Load())
+	}
+	return loadPointer(&n.child[bit])
+}
+
+func (t *lpmTrie) loadSlot(s nodeSlot) *lpmTrieNode {
+	if s.parent == nil {
+		return t.rootNode()
+	}
+	return t.childNode(s.parent, s.bit)
+}
+
+func (t *lpmTrie) storeSlot(s nodeSlot, n *lpmTrieNode) {
+	if t.arena == nil {
+		if s.parent == nil {
+			storePointer(&t.root, n)
+		} else {
+			storePointer(&s.parent.child[s.bit], n)
+		}
+		return
+	}
+
+	var idx uint32
+	if n != nil {
+		idx = n.idx
+	}
+	if s.parent == nil {
+		t.arena.root.Store(idx)
+	} else {
+		t.arena.links(s.parent)[s.bit].Store(idx)
+	}
 }
 
-func loadPointer(ptr *unsafe.Pointer) *lpmTrieNode {
-	return (*lpmTrieNode)(atomic.LoadPointer(ptr))
+func (t *lpmTrie) setChild(n *lpmTrieNode, bit byte, child *lpmTrieNode) {
+	t.storeSlot(nodeSlot{parent: n, bit: bit}, child)
 }
 
-func storePointer(ptr *unsafe.Pointer, node *lpmTrieNode) {
-	atomic.StorePointer(ptr, unsafe.Pointer(node))
+func (t *lpmTrie) newNode(key keyBits, value interface{}) *lpmTrieNode {
+	if t.arena == nil {
+		return newLpmTrieNode(key, value)
+	}
+
+	n := t.arena.alloc()
+	n.init(key, value)
+	return n
+}
+
+// newNodeWithIm creates the node and the intermediate node above it. The key
+// of the intermediate node is cut to imPrefixLen bits, since it turns into
+// the key of an entry once the intermediate node is updated.
+func (t *lpmTrie) newNodeWithIm(key keyBits, value interface{}, imPrefixLen int) (node, imNode *lpmTrieNode) {
+	imKey := t.truncateBits(key, imPrefixLen)
+	if t.arena == nil {
+		return newLpmTrieNodeWithIm(key, value, imKey)
+	}
+
+	node = t.newNode(key, value)
+	imNode = t.newNode(imKey, nil)
+	imNode.setIm(true)
+	return node, imNode
+}
+
+// enter keeps the nodes reachable since now from being recycled until exit,
+// for the tries created WithArena.
+func (t *lpmTrie) enter() uint64 {
+	if t.arena == nil {
+		return 0
+	}
+	return t.arena.enter()
+}
+
+func (t *lpmTrie) exit(epoch uint64) {
+	if t.arena != nil {
+		t.arena.exit(epoch)
+	}
 }
 
 type lpmTrie struct {
-	root         unsafe.Pointer // *lpmTrieNode
+	mu           sync.Mutex // serializes writers
+	root         atomic.Pointer[lpmTrieNode]
 	size         int64
 	maxPrefixLen int
 	keySize      int
+	stride       *strideTable     // nil for BackendBinary
+	arena        *nodeArena       // nil unless WithArena
+	observer     Observer         // nil unless WithObserver
+	now          func() time.Time // time.Now unless WithClock
+	expiring     atomic.Int64     // number of entries updated with a TTL
 }
 
 var _ LpmTrie = (*lpmTrie)(nil)
 
-func New(maxPrefixLen int) (LpmTrie, error) {
-	if maxPrefixLen <= 0 || maxPrefixLen%8 != 0 {
-		return nil, errors.New("maxPrefixLen must be positive and be times of 8")
+// Backend is the structure used by the trie to look up keys.
+type Backend int
+
+const (
+	// BackendBinary looks up keys by walking the binary trie, one node per
+	// branching bit.
+	BackendBinary Backend = iota
+
+	// BackendStride additionally keeps a path-compressed multibit stride
+	// table, which looks up keys of max prefix length with one node per
+	// stride of bits. It costs more memory and slower updates.
+	BackendStride
+)
+
+// Option configures the trie created by New.
+type Option func(*options)
+
+type options struct {
+	backend  Backend
+	arena    bool
+	observer Observer
+	clock    func() time.Time
+}
+
+// WithBackend selects the backend of the trie, BackendBinary by default.
+func WithBackend(backend Backend) Option {
+	return func(o *options) {
+		o.backend = backend
+	}
+}
+
+// WithArena allocates the nodes of the trie from large slabs linking nodes
+// by indices, which relieves the GC of tries with millions of entries. The
+// nodes removed by Delete are recycled once no reader can reach them.
+// The GC still scans the slabs for the values, but follows no pointer
+// between nodes. Lookups cost more than with BackendBinary, since they
+// count themselves in and out of an epoch and map indices to nodes, up to
+// about 1.5x for IPv4 tables of 100k routes.
+// It can't be used with BackendStride.
+func WithArena() Option {
+	return func(o *options) {
+		o.arena = true
+	}
+}
+
+func New(maxPrefixLen int, opts ...Option) (LpmTrie, error) {
+	if maxPrefixLen <= 0 {
+		return nil, errors.New("maxPrefixLen must be positive")
+	}
+
+	var o options
+	for _, opt := range opts {
+		opt(&o)
 	}
 
 	var t lpmTrie
-	storePointer(&t.root, nilNode)
 	t.maxPrefixLen = maxPrefixLen
-	t.keySize = maxPrefixLen / 8
+	t.keySize = (maxPrefixLen + 7) / 8
+
+	switch o.backend {
+	case BackendBinary:
+	case BackendStride:
+		if o.arena {
+			return nil, errors.New("arena can't be used with stride backend")
+		}
+		t.stride = newStrideTable(t.keySize)
+	default:
+		return nil, errors.New("unknown backend")
+	}
+
+	if o.arena {
+		t.arena = newNodeArena()
+	}
+
+	t.observer = o.observer
+
+	t.now = time.Now
+	if o.clock != nil {
+		t.now = o.clock
+	}
+
 	return &t, nil
 }
 
@@ -124,6 +475,10 @@ func (t *lpmTrie) Size() int64 {
 	return atomic.LoadInt64(&t.size)
 }
 
+func (t *lpmTrie) MaxPrefixLen() int {
+	return t.maxPrefixLen
+}
+
 func (t *lpmTrie) isValidKey(key Key) bool {
 	return 0 <= key.PrefixLen && key.PrefixLen <= t.maxPrefixLen && len(key.Data) == t.keySize
 }
@@ -145,56 +500,33 @@ func min(a, b int) int {
 	return b
 }
 
-func (t *lpmTrie) longestPrefixMatch(node *lpmTrieNode, key Key) int {
-	limit := min(node.PrefixLen, key.PrefixLen)
-	prefixlen, i := 0, 0
-	be := binary.BigEndian
-
-	for ; t.keySize >= i+4; i += 4 {
-		diff := be.Uint32(node.Data[i:i+4]) ^ be.Uint32(key.Data[i:i+4])
+func (t *lpmTrie) Lookup(key Key) (val interface{}, ok bool) {
+	t.checkKey(key)
 
-		prefixlen += bits.LeadingZeros32(diff)
-		if prefixlen >= limit {
-			return limit
-		}
-		if diff != 0 {
-			return prefixlen
-		}
+	if t.observer != nil {
+		defer t.observeLookup(time.Now(), &ok)
 	}
 
-	if t.keySize >= i+2 {
-		diff := be.Uint16(node.Data[i:i+2]) ^ be.Uint16(key.Data[i:i+2])
-
-		prefixlen += bits.LeadingZeros16(diff)
-		if prefixlen >= limit {
-			return limit
-		}
-		if diff != 0 {
-			return prefixlen
-		}
+	return t.lookup(key)
+}
 
-		i += 2
+func (t *lpmTrie) lookup(key Key) (interface{}, bool) {
+	if t.expiring.Load() != 0 {
+		// The stride table doesn't know about the expired entries.
+		return t.lookupExpiring(key)
 	}
 
-	if t.keySize >= i+1 {
-		diff := node.Data[i] ^ key.Data[i]
-
-		prefixlen += bits.LeadingZeros8(diff)
-		if prefixlen >= limit {
-			return limit
-		}
+	if t.stride != nil && key.PrefixLen == t.maxPrefixLen {
+		return t.stride.lookup(key)
 	}
 
-	return prefixlen
-}
-
-func (t *lpmTrie) Lookup(key Key) (interface{}, bool) {
-	t.checkKey(key)
+	defer t.exit(t.enter())
 
 	var found *lpmTrieNode
 
-	for node := loadPointer(&t.root); node != nil; node = loadPointer(&node.child[extractBit(key.Data, node.PrefixLen)]) {
-		matchlen := t.longestPrefixMatch(node, key)
+	k := t.bitsOf(key)
+	for node := t.rootNode(); node != nil; node = t.childNode(node, k.bit(node.PrefixLen)) {
+		matchlen := t.longestPrefixMatch(node, k)
 		if matchlen == t.maxPrefixLen {
 			return node.loadValue(), true
 		}
@@ -218,68 +550,153 @@ func (t *lpmTrie) Lookup(key Key) (interface{}, bool) {
 func (t *lpmTrie) Update(key Key, val interface{}) (updated bool) {
 	t.checkKey(key)
 
-	atomic.AddInt64(&t.size, 1)
+	if t.observer != nil {
+		defer t.observeUpdate(time.Now(), &updated)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.update(key, val)
+}
+
+func (t *lpmTrie) update(key Key, val interface{}) (updated bool) {
+	t.purge(key)
+
+	// Count the entry with a TTL before readers can see it, and the one it
+	// replaces after they no longer can.
+	t.countExpiring(val, 1)
+	old, updated := t.updateNode(key, val)
+	if t.stride != nil {
+		t.stride.refresh(t, key)
+	}
+	t.countExpiring(old, -1)
+	if t.arena != nil {
+		t.arena.reclaim()
+	}
+	return updated
+}
 
-	newnode := newLpmTrieNode(key, val)
-	slot := &t.root
+// updateNode updates the entry of the key, and returns the value it replaces
+// as stored in the node.
+func (t *lpmTrie) updateNode(key Key, val interface{}) (old interface{}, updated bool) {
+	k := t.bitsOf(key)
+	slot := nodeSlot{}
 
 	matchlen := 0
-	node := loadPointer(slot)
-	for ; node != nil; node = loadPointer(slot) {
-		matchlen = t.longestPrefixMatch(node, key)
+	node := t.loadSlot(slot)
+	for ; node != nil; node = t.loadSlot(slot) {
+		matchlen = t.longestPrefixMatch(node, k)
 		if node.PrefixLen != matchlen ||
 			node.PrefixLen == key.PrefixLen ||
 			node.PrefixLen == t.maxPrefixLen {
 			break
 		}
 
-		slot = &node.child[extractBit(key.Data, node.PrefixLen)]
+		slot = nodeSlot{parent: node, bit: k.bit(node.PrefixLen)}
 	}
 
 	if node == nil {
-		storePointer(slot, newnode)
-		return false
+		t.storeSlot(slot, t.newNode(t.nodeBitsOf(key), val))
+		atomic.AddInt64(&t.size, 1)
+		return nil, false
 	}
 
 	if node.PrefixLen == matchlen {
-		newnode.child = node.child
+		if !node.isIm() {
+			old = node.value.Load().v
+		}
+
+		if !t.sameBits(&node.keyBits, &k) {
+			// The key differs in the bits after the prefix length, so
+			// the node is replaced to report the key as given.
+			newnode := t.newNode(t.nodeBitsOf(key), val)
+			t.setChild(newnode, 0, t.childNode(node, 0))
+			t.setChild(newnode, 1, t.childNode(node, 1))
+			t.storeSlot(slot, newnode)
+			t.retire(node)
+			if node.isIm() {
+				atomic.AddInt64(&t.size, 1)
+				return nil, false
+			}
+			return old, true
+		}
+
+		// Replace the value in place, the node keeps its children. An
+		// intermediate node turns into a new entry, so it's not an update.
+		node.storeValue(&nodeValue{v: val})
 
 		if !node.isIm() {
-			atomic.AddInt64(&t.size, -1)
+			return old, true
 		}
+		node.setIm(false)
+		atomic.AddInt64(&t.size, 1)
+		return nil, false
+	}
 
-		storePointer(slot, newnode)
-		return true
+	if matchlen == key.PrefixLen {
+		// newnode covers node, so node becomes its child.
+		newnode := t.newNode(t.nodeBitsOf(key), val)
+		t.setChild(newnode, node.bit(matchlen), node)
+		t.storeSlot(slot, newnode)
+		atomic.AddInt64(&t.size, 1)
+		return nil, false
 	}
 
-	imNode := newLpmTrieNode(key, nil)
-	imNode.PrefixLen = matchlen
-	imNode.setIm(true)
+	newnode, imNode := t.newNodeWithIm(t.nodeBitsOf(key), val, matchlen)
 
-	nextBit := extractBit(key.Data, matchlen)
-	if nextBit != 0 {
-		storePointer(&imNode.child[0], node)
-		storePointer(&imNode.child[1], newnode)
-	} else {
-		storePointer(&imNode.child[0], newnode)
-		storePointer(&imNode.child[1], node)
-	}
+	nextBit := k.bit(matchlen)
+	t.setChild(imNode, nextBit, newnode)
+	t.setChild(imNode, 1-nextBit, node)
 
-	storePointer(slot, imNode)
+	t.storeSlot(slot, imNode)
+	atomic.AddInt64(&t.size, 1)
 
-	return false
+	return nil, false
 }
 
 func (t *lpmTrie) Delete(key Key) (deleted bool) {
 	t.checkKey(key)
 
+	if t.observer != nil {
+		defer t.observeDelete(time.Now(), &deleted)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.purge(key)
+	_, deleted = t.delete(key)
+	return deleted
+}
+
+// delete deletes the entry of the key, and returns its value.
+func (t *lpmTrie) delete(key Key) (val interface{}, deleted bool) {
+	val, deleted = t.deleteNode(key)
+	if deleted && t.stride != nil {
+		t.stride.refresh(t, key)
+	}
+	if e, ok := val.(*expiringValue); ok {
+		t.expiring.Add(-1)
+		val = e.v
+	}
+	if t.arena != nil {
+		t.arena.reclaim()
+	}
+	return val, deleted
+}
+
+// deleteNode deletes the entry of the key, and returns its value as stored
+// in the node.
+func (t *lpmTrie) deleteNode(key Key) (val interface{}, deleted bool) {
 	var parent *lpmTrieNode
-	trim := &t.root
+	k := t.bitsOf(key)
+	trim := nodeSlot{}
 	trim2 := trim
 	matchlen := 0
-	node := loadPointer(trim)
-	for ; node != nil; node = loadPointer(trim) {
-		matchlen = t.longestPrefixMatch(node, key)
+	node := t.loadSlot(trim)
+	for ; node != nil; node = t.loadSlot(trim) {
+		matchlen = t.longestPrefixMatch(node, k)
 
 		if node.PrefixLen != matchlen ||
 			node.PrefixLen == key.PrefixLen {
@@ -288,64 +705,105 @@ func (t *lpmTrie) Delete(key Key) (deleted bool) {
 
 		parent = node
 		trim2 = trim
-		trim = &node.child[extractBit(key.Data, node.PrefixLen)]
+		trim = nodeSlot{parent: node, bit: k.bit(node.PrefixLen)}
 	}
 
 	if node == nil ||
 		node.PrefixLen != key.PrefixLen ||
 		node.PrefixLen != matchlen ||
 		node.isIm() {
-		return false
+		return nil, false
 	}
 
 	atomic.AddInt64(&t.size, -1)
-
-	if loadPointer(&node.child[0]) != nil &&
-		loadPointer(&node.child[1]) != nil {
-		node.storeValue(prunedValue) // Note: free the value
-		node.setIm(true)             // mark as intermediate node
-		return true
+	val = node.value.Load().v
+
+	left, right := t.childNode(node, 0), t.childNode(node, 1)
+	if left != nil && right != nil {
+		// Replace the node with an intermediate one, the readers may still
+		// load the value of node.
+		imNode := t.newNode(node.keyBits, nil)
+		imNode.setIm(true)
+		t.setChild(imNode, 0, left)
+		t.setChild(imNode, 1, right)
+		t.storeSlot(trim, imNode)
+		t.retire(node)
+		return val, true
 	}
 
 	if parent != nil &&
 		parent.isIm() &&
-		loadPointer(&node.child[0]) == nil &&
-		loadPointer(&node.child[1]) == nil {
-		if node == loadPointer(&parent.child[0]) {
-			storePointer(trim2, loadPointer(&parent.child[1]))
-		} else {
-			storePointer(trim2, loadPointer(&parent.child[0]))
-		}
-		return true
+		left == nil && right == nil {
+		t.storeSlot(trim2, t.childNode(parent, 1-trim.bit))
+		t.retire(parent)
+		t.retire(node)
+		return val, true
 	}
 
-	if loadPointer(&node.child[0]) != nil {
-		storePointer(trim, loadPointer(&node.child[0]))
-	} else if loadPointer(&node.child[1]) != nil {
-		storePointer(trim, loadPointer(&node.child[1]))
+	if left != nil {
+		t.storeSlot(trim, left)
 	} else {
-		storePointer(trim, nil)
+		t.storeSlot(trim, right)
+	}
+	t.retire(node)
+	return val, true
+}
+
+// retire releases the node removed from the trie.
+func (t *lpmTrie) retire(n *lpmTrieNode) {
+	if t.arena != nil {
+		t.arena.retire(n)
+		return
+	}
+
+	// The memory of n is freed with its partner, so the partner moves out.
+	if p := n.partner(); p != nil {
+		t.rehome(p)
+	}
+}
+
+// rehome replaces the node n with a copy of its own, if n is still in the
+// trie.
+func (t *lpmTrie) rehome(n *lpmTrieNode) {
+	slot := nodeSlot{}
+	node := t.loadSlot(slot)
+	for node != nil && node != n && node.PrefixLen < n.PrefixLen {
+		slot = nodeSlot{parent: node, bit: n.bit(node.PrefixLen)}
+		node = t.loadSlot(slot)
+	}
+	if node != n {
+		return
+	}
+
+	c := newLpmTrieNode(n.keyBits, n.value.Load().v)
+	c.setIm(n.isIm())
+	t.setChild(c, 0, t.childNode(n, 0))
+	t.setChild(c, 1, t.childNode(n, 1))
+	t.storeSlot(slot, c)
+
+	if t.stride != nil && !n.isIm() {
+		t.stride.refresh(t, t.keyOf(&n.keyBits))
 	}
-	return true
 }
 
 func (t *lpmTrie) Range(fn func(key Key, val interface{}) bool) {
-	_ = t.traverse(&t.root, fn)
+	defer t.exit(t.enter())
+
+	_ = t.traverse(t.rootNode(), fn)
 }
 
-func (t *lpmTrie) traverse(root *unsafe.Pointer, fn func(key Key, val interface{}) bool) (terminated bool) {
-	node := loadPointer(root)
+func (t *lpmTrie) traverse(node *lpmTrieNode, fn func(key Key, val interface{}) bool) (terminated bool) {
 	if node == nil {
 		return false
 	}
 
-	if t.traverse(&node.child[0], fn) {
+	if t.traverse(t.childNode(node, 0), fn) {
 		return true
 	}
 
-	if !node.isIm() && !fn(node.Key, node.loadValue()) {
+	if !node.isIm() && !t.expired(node) && !fn(t.keyOf(&node.keyBits), node.loadValue()) {
 		return true
 	}
 
-	return t.traverse(&node.child[1], fn)
+	return t.traverse(t.childNode(node, 1), fn)
 }
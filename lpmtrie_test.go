@@ -2,9 +2,23 @@ package lpmtrie
 
 import (
 	"bytes"
+	"math/rand"
+	"runtime"
 	"testing"
+	"time"
 )
 
+// testBackends are the options of every backend, for the tests run on each
+// of them.
+var testBackends = []struct {
+	name string
+	opts []Option
+}{
+	{"binary", nil},
+	{"stride", []Option{WithBackend(BackendStride)}},
+	{"arena", []Option{WithArena()}},
+}
+
 func TestNilNode(t *testing.T) {
 	if nilNode != nil {
 		t.Errorf("nilNode should be nil")
@@ -71,7 +85,7 @@ func TestExtractBit(t *testing.T) {
 func TestLongestPrefixMatch(t *testing.T) {
 	key1 := Key{PrefixLen: 32}
 	key2 := Key{PrefixLen: 32}
-	node1 := lpmTrieNode{Key: key1}
+	var node1 lpmTrieNode
 
 	lt, _ := New(key1.PrefixLen)
 	trie := lt.(*lpmTrie)
@@ -148,9 +162,9 @@ func TestLongestPrefixMatch(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			key1.Data = tt.data1
 			key2.Data = tt.data2
-			node1.Key = key1
+			node1.keyBits = trie.bitsOf(key1)
 
-			if pl := trie.longestPrefixMatch(&node1, key2); pl != tt.expectPrefixlen {
+			if pl := trie.longestPrefixMatch(&node1, trie.bitsOf(key2)); pl != tt.expectPrefixlen {
 				t.Errorf("expected longest prefix match to be %d, got %d", tt.expectPrefixlen, pl)
 			}
 		})
@@ -176,13 +190,13 @@ func TestLPMandExtractBit(t *testing.T) {
 				key2 := Key{plen, []byte{0b01000000, 0, 0, 0}}
 
 				key := Key{plen, []byte{0b11000000, 0, 0, 0}}
-				node := lpmTrieNode{Key: key}
+				node := newLpmTrieNode(trie.bitsOf(key), nil)
 
-				matchlen1 := trie.longestPrefixMatch(&node, key1)
+				matchlen1 := trie.longestPrefixMatch(node, trie.bitsOf(key1))
 				if matchlen1 != 1 {
 					t.Fatalf("expected longest prefix match1 to be 1, got %d", matchlen1)
 				}
-				matchlen2 := trie.longestPrefixMatch(&node, key2)
+				matchlen2 := trie.longestPrefixMatch(node, trie.bitsOf(key2))
 				if matchlen2 != 0 {
 					t.Fatalf("expected longest prefix match2 to be 0, got %d", matchlen2)
 				}
@@ -207,10 +221,7 @@ func TestLPMandExtractBit(t *testing.T) {
 }
 
 func printTrie(trie *lpmTrie, t *testing.T) {
-	trie.Range(func(key Key, val interface{}) bool {
-		t.Logf("%+v: %+v\n", key, val)
-		return true
-	})
+	t.Logf("\n%s", trie)
 }
 
 var _ = printTrie
@@ -241,8 +252,8 @@ func TestLookup(t *testing.T) {
 			"one node",
 			func(t *testing.T) {
 				key := Key{PrefixLen: plen, Data: []byte{0, 0, 0, 0}}
-				node := lpmTrieNode{Key: key}
-				trie.Update(key, &node)
+				node := newLpmTrieNode(trie.bitsOf(key), nil)
+				trie.Update(key, node)
 
 				_, ok := trie.Lookup(key)
 				if !ok {
@@ -293,6 +304,23 @@ func TestLookup(t *testing.T) {
 				}
 			},
 		},
+		{
+			"shorter prefix after longer one",
+			func(t *testing.T) {
+				trie.Update(Key{16, []byte{10, 1, 0, 0}}, 16)
+				trie.Update(Key{8, []byte{10, 0, 0, 0}}, 8)
+
+				v, ok := trie.Lookup(Key{plen, []byte{10, 1, 2, 3}})
+				if !ok || v.(int) != 16 {
+					t.Errorf("expected lookup to match the longer prefix, got %v", v)
+				}
+
+				v, ok = trie.Lookup(Key{plen, []byte{10, 200, 0, 0}})
+				if !ok || v.(int) != 8 {
+					t.Errorf("expected lookup to match the shorter prefix, got %v", v)
+				}
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -612,6 +640,52 @@ func TestSize(t *testing.T) {
 				}
 			},
 		},
+		{
+			"replace node",
+			func(t *testing.T) {
+				key := Key{plen, []byte{0b10100000, 0, 0, 0}}
+				trie.Update(key, 1)
+				if !trie.Update(key, 2) {
+					t.Errorf("expected update of existing key to be updated")
+				}
+
+				if trie.Size() != 1 {
+					t.Errorf("expected size to be 1")
+				}
+			},
+		},
+		{
+			"replace intermediate node",
+			func(t *testing.T) {
+				key := Key{plen, []byte{0b10100000, 0, 0, 0}}
+				trie.Update(key, 1)
+				key = Key{plen, []byte{0b11100000, 0, 0, 0}}
+				trie.Update(key, 2)
+
+				key = Key{1, []byte{0b10000000, 0, 0, 0}}
+				if trie.Update(key, 3) {
+					t.Errorf("expected update of intermediate node not to be updated")
+				}
+				if trie.Size() != 3 {
+					t.Errorf("expected size to be 3")
+				}
+
+				if !trie.Update(key, 4) {
+					t.Errorf("expected update of existing key to be updated")
+				}
+				if trie.Size() != 3 {
+					t.Errorf("expected size to be 3")
+				}
+
+				trie.Delete(key)
+				if trie.Size() != 2 {
+					t.Errorf("expected size to be 2")
+				}
+				if err := trie.Validate(); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -622,6 +696,102 @@ func TestSize(t *testing.T) {
 	}
 }
 
+// TestSizeModel runs random operations on tries and a map of the entries as
+// the reference model, and checks that they agree after each operation.
+func TestSizeModel(t *testing.T) {
+	for _, plen := range []int{1, 8, 12, 20, 32, 128, 131, 136} {
+		for _, opts := range [][]Option{nil, {WithBackend(BackendStride)}, {WithArena()}} {
+			rng := rand.New(rand.NewSource(int64(plen)))
+
+			lt, _ := New(plen, opts...)
+			trie := lt.(*lpmTrie)
+			model := make(map[string]int)
+
+			// Keys are picked from a few addresses, so that they share
+			// prefixes, and intermediate nodes are replaced by the keys of
+			// the common prefix of two addresses.
+			addrs := make([][]byte, 8)
+			for i := range addrs {
+				addrs[i] = make([]byte, (plen+7)/8)
+				rng.Read(addrs[i])
+				addrs[i][0] &= 0x0f
+			}
+			randomKey := func() Key {
+				data := make([]byte, (plen+7)/8)
+				copy(data, addrs[rng.Intn(len(addrs))])
+
+				prefixLen := plen
+				switch rng.Intn(3) {
+				case 0:
+					prefixLen = rng.Intn(plen + 1)
+				case 1:
+					other := addrs[rng.Intn(len(addrs))]
+					prefixLen = commonPrefixLen(data, other, plen)
+				}
+				return Key{prefixLen, data}
+			}
+			modelKey := func(key Key) string {
+				data := append([]byte(nil), key.Data...)
+				clearHostBits(data, key.PrefixLen)
+				return string(rune(key.PrefixLen)) + string(data)
+			}
+			modelLookup := func(key Key) (int, bool) {
+				for prefixLen := key.PrefixLen; prefixLen >= 0; prefixLen-- {
+					if v, ok := model[modelKey(Key{prefixLen, key.Data})]; ok {
+						return v, true
+					}
+				}
+				return 0, false
+			}
+
+			for i := 0; i < 5000; i++ {
+				key := randomKey()
+
+				switch rng.Intn(3) {
+				case 0:
+					_, exists := model[modelKey(key)]
+					if updated := trie.Update(key, i); updated != exists {
+						t.Fatalf("update of %v: expected updated to be %v", key, exists)
+					}
+					model[modelKey(key)] = i
+				case 1:
+					_, exists := model[modelKey(key)]
+					if deleted := trie.Delete(key); deleted != exists {
+						t.Fatalf("delete of %v: expected deleted to be %v", key, exists)
+					}
+					delete(model, modelKey(key))
+				default:
+					expect, ok := modelLookup(key)
+					if v, found := trie.Lookup(key); found != ok || (ok && v.(int) != expect) {
+						t.Fatalf("lookup of %v: expected %v %v, got %v %v", key, expect, ok, v, found)
+					}
+				}
+
+				if trie.Size() != int64(len(model)) {
+					t.Fatalf("after %d operations: expected size to be %d, got %d", i+1, len(model), trie.Size())
+				}
+				if i%100 == 0 {
+					if err := trie.Validate(); err != nil {
+						t.Fatalf("after %d operations: %v", i+1, err)
+					}
+				}
+			}
+
+			n := 0
+			trie.Range(func(key Key, val interface{}) bool {
+				if v, ok := model[modelKey(key)]; !ok || v != val.(int) {
+					t.Fatalf("unexpected entry %v: %v", key, val)
+				}
+				n++
+				return true
+			})
+			if n != len(model) {
+				t.Errorf("expected %d entries, got %d", len(model), n)
+			}
+		}
+	}
+}
+
 func TestRange(t *testing.T) {
 	const plen = 32
 	var trie *lpmTrie
@@ -670,3 +840,212 @@ func TestRange(t *testing.T) {
 		})
 	}
 }
+
+func TestRangePromotedKey(t *testing.T) {
+	tests := []struct {
+		name string
+		plen int
+		opts []Option
+		keys []Key // the last one is promoted from an intermediate node
+		data []byte
+		size int64
+	}{
+		{"binary", 32, nil, []Key{{32, []byte{10, 0, 0, 1}}, {32, []byte{10, 0, 0, 2}}, {30, []byte{10, 0, 0, 0}}}, []byte{10, 0, 0, 0}, 3},
+		{"host bits", 32, nil, []Key{{32, []byte{10, 0, 0, 1}}, {32, []byte{10, 0, 0, 2}}, {30, []byte{10, 0, 0, 3}}}, []byte{10, 0, 0, 3}, 3},
+		{"replace", 32, nil, []Key{{30, []byte{10, 0, 0, 0}}, {30, []byte{10, 0, 0, 3}}}, []byte{10, 0, 0, 3}, 1},
+		{"stride", 32, []Option{WithBackend(BackendStride)}, []Key{{32, []byte{10, 0, 0, 1}}, {32, []byte{10, 0, 0, 2}}, {30, []byte{10, 0, 0, 0}}}, []byte{10, 0, 0, 0}, 3},
+		{"arena", 32, []Option{WithArena()}, []Key{{32, []byte{10, 0, 0, 1}}, {32, []byte{10, 0, 0, 2}}, {30, []byte{10, 0, 0, 0}}}, []byte{10, 0, 0, 0}, 3},
+		{"wide", 136, nil, []Key{{136, append(make([]byte, 16), 1)}, {136, append(make([]byte, 16), 2)}, {134, make([]byte, 17)}}, make([]byte, 17), 3},
+		{"wide short", 136, nil, []Key{{136, append([]byte{1}, make([]byte, 16)...)}, {136, append([]byte{2}, make([]byte, 16)...)}, {6, make([]byte, 17)}}, make([]byte, 17), 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trie, _ := New(tt.plen, tt.opts...)
+			for i, key := range tt.keys {
+				trie.Update(key, i)
+			}
+
+			last := tt.keys[len(tt.keys)-1]
+			found := false
+			trie.Range(func(key Key, val interface{}) bool {
+				if key.PrefixLen != last.PrefixLen {
+					return true
+				}
+				found = true
+				if !bytes.Equal(key.Data, tt.data) {
+					t.Errorf("expected key data %v, got %v", tt.data, key.Data)
+				}
+				if val != len(tt.keys)-1 {
+					t.Errorf("expected value %d, got %v", len(tt.keys)-1, val)
+				}
+				return true
+			})
+			if !found {
+				t.Errorf("expected key %v in range", last)
+			}
+			if trie.Size() != tt.size {
+				t.Errorf("expected size %d, got %d", tt.size, trie.Size())
+			}
+			if err := trie.Validate(); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteFreesValue(t *testing.T) {
+	const plen = 32
+
+	// freed returns a value and a channel closed once it's garbage collected.
+	freed := func() (interface{}, chan struct{}) {
+		done := make(chan struct{})
+		v := new([64]byte)
+		runtime.SetFinalizer(v, func(*[64]byte) { close(done) })
+		return v, done
+	}
+	collected := func(done chan struct{}) bool {
+		for i := 0; i < 20; i++ {
+			runtime.GC()
+			select {
+			case <-done:
+				return true
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		return false
+	}
+
+	for _, opts := range [][]Option{nil, {WithBackend(BackendStride)}} {
+		trie, _ := New(plen, opts...)
+
+		// The leaf is removed, the node allocated with its intermediate
+		// parent stays.
+		v, done := freed()
+		trie.Update(Key{plen, []byte{10, 0, 0, 1}}, v)
+		trie.Update(Key{plen, []byte{10, 0, 0, 2}}, 2)
+		trie.Delete(Key{plen, []byte{10, 0, 0, 1}})
+		v = nil
+		if !collected(done) {
+			t.Errorf("expected value of deleted leaf to be freed")
+		}
+
+		// The entry turns into an intermediate node.
+		v, done = freed()
+		trie.Update(Key{24, []byte{10, 1, 1, 0}}, v)
+		trie.Update(Key{plen, []byte{10, 1, 1, 1}}, 1)
+		trie.Update(Key{plen, []byte{10, 1, 1, 129}}, 129)
+		trie.Delete(Key{24, []byte{10, 1, 1, 0}})
+		v = nil
+		if !collected(done) {
+			t.Errorf("expected value of deleted entry with two children to be freed")
+		}
+
+		if v, _ := trie.Lookup(Key{plen, []byte{10, 0, 0, 2}}); v != 2 {
+			t.Errorf("expected value 2, got %v", v)
+		}
+		if v, _ := trie.Lookup(Key{plen, []byte{10, 1, 1, 129}}); v != 129 {
+			t.Errorf("expected value 129, got %v", v)
+		}
+		if err := trie.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestAllocs(t *testing.T) {
+	const plen = 32
+	const runs = 100
+
+	var val interface{} = "value"
+
+	lt, _ := New(plen)
+	trie := lt.(*lpmTrie)
+
+	keys := make([]Key, 2*(runs+1))
+	for i := range keys {
+		keys[i] = Key{plen, []byte{10, byte(i), 0, 1}}
+	}
+
+	i := 0
+	if n := testing.AllocsPerRun(runs, func() {
+		trie.Update(keys[i], val)
+		i++
+	}); n > 1 {
+		t.Errorf("expected update of new key to allocate at most once, got %v", n)
+	}
+
+	if n := testing.AllocsPerRun(runs, func() {
+		trie.Update(keys[0], val)
+	}); n > 1 {
+		t.Errorf("expected update of existing key to allocate at most once, got %v", n)
+	}
+
+	if n := testing.AllocsPerRun(runs, func() {
+		trie.Lookup(keys[1])
+		trie.Lookup(keys[len(keys)-1])
+	}); n != 0 {
+		t.Errorf("expected lookup not to allocate, got %v", n)
+	}
+
+	lt, _ = New(plen, WithBackend(BackendStride))
+	lt.Update(keys[0], val)
+	if n := testing.AllocsPerRun(runs, func() {
+		lt.Lookup(keys[0])
+	}); n != 0 {
+		t.Errorf("expected lookup of stride backend not to allocate, got %v", n)
+	}
+}
+
+func TestArbitraryWidth(t *testing.T) {
+	for _, plen := range []int{0, -1} {
+		if _, err := New(plen); err == nil {
+			t.Errorf("expected max prefix length %d to fail", plen)
+		}
+	}
+
+	tests := []struct {
+		plen int
+		key  Key // with garbage after the max prefix length
+		host Key
+		miss Key
+		data []byte // the data of key in Range
+	}{
+		{1, Key{1, []byte{0xff}}, Key{1, []byte{0x80}}, Key{1, []byte{0x7f}}, []byte{0x80}},
+		{12, Key{12, []byte{0xab, 0xcf}}, Key{12, []byte{0xab, 0xc1}}, Key{12, []byte{0xab, 0xdf}}, []byte{0xab, 0xc0}},
+		{20, Key{16, []byte{0x12, 0x34, 0x5f}}, Key{20, []byte{0x12, 0x34, 0xf0}}, Key{20, []byte{0x12, 0x35, 0x00}}, []byte{0x12, 0x34, 0x50}},
+		{48, Key{24, []byte{0, 0x1b, 0x21, 0x3a, 0x4b, 0x5c}}, Key{48, []byte{0, 0x1b, 0x21, 1, 2, 3}}, Key{48, []byte{0, 0x1b, 0x22, 1, 2, 3}}, []byte{0, 0x1b, 0x21, 0x3a, 0x4b, 0x5c}},
+		{130, Key{130, append(make([]byte, 16), 0xff)}, Key{130, append(make([]byte, 16), 0xc0)}, Key{130, append(make([]byte, 16), 0x80)}, append(make([]byte, 16), 0xc0)},
+	}
+
+	for _, tt := range tests {
+		lt, err := New(tt.plen)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		trie := lt.(*lpmTrie)
+		if trie.keySize != len(tt.key.Data) {
+			t.Errorf("expected key size of %d bits to be %d, got %d", tt.plen, len(tt.key.Data), trie.keySize)
+		}
+
+		trie.Update(tt.key, 1)
+		if v, ok := trie.Lookup(tt.host); !ok || v.(int) != 1 {
+			t.Errorf("%d bits: expected lookup of %v to succeed", tt.plen, tt.host)
+		}
+		if _, ok := trie.Lookup(tt.miss); ok {
+			t.Errorf("%d bits: expected lookup of %v to fail", tt.plen, tt.miss)
+		}
+
+		trie.Range(func(key Key, val interface{}) bool {
+			if !bytes.Equal(key.Data, tt.data) {
+				t.Errorf("%d bits: expected key data %v, got %v", tt.plen, tt.data, key.Data)
+			}
+			return true
+		})
+
+		if !trie.Delete(Key{tt.key.PrefixLen, tt.host.Data}) || trie.Size() != 0 {
+			t.Errorf("%d bits: expected delete of %v to succeed", tt.plen, tt.key)
+		}
+	}
+}
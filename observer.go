@@ -0,0 +1,69 @@
+package lpmtrie
+
+import "time"
+
+// Observer observes the operations of a trie, to export metrics of them.
+//
+// The methods are called by Lookup, LookupBatch, Update and Delete after the
+// operation is done, with whether the key is matched, inserted or deleted
+// and the latency of the operation. Upsert, a successful CompareAndSwap and
+// a storing LoadOrStore are observed as updates, and LoadAndDelete is
+// observed as a delete. They are called concurrently by the readers, so
+// they must be safe for concurrent use and be fast.
+type Observer interface {
+	// ObserveLookup observes a lookup, hit is false if no entry matched.
+	// LookupBatch observes a lookup per key with the average latency.
+	ObserveLookup(hit bool, latency time.Duration)
+
+	// ObserveUpdate observes an update, replaced is false if the key was
+	// inserted.
+	ObserveUpdate(replaced bool, latency time.Duration)
+
+	// ObserveDelete observes a delete, hit is false if the key was not found.
+	ObserveDelete(hit bool, latency time.Duration)
+}
+
+// WithObserver reports the operations of the trie to observer. The operations
+// are timed only if there is an observer.
+func WithObserver(observer Observer) Option {
+	return func(o *options) {
+		o.observer = observer
+	}
+}
+
+func (t *lpmTrie) observeLookup(start time.Time, hit *bool) {
+	t.observer.ObserveLookup(*hit, time.Since(start))
+}
+
+func (t *lpmTrie) observeLookupBatch(start time.Time, found []bool) {
+	if len(found) == 0 {
+		return
+	}
+
+	latency := time.Since(start) / time.Duration(len(found))
+	for _, hit := range found {
+		t.observer.ObserveLookup(hit, latency)
+	}
+}
+
+func (t *lpmTrie) observeUpdate(start time.Time, replaced *bool) {
+	t.observer.ObserveUpdate(*replaced, time.Since(start))
+}
+
+// observeSwap observes a successful swap as a replace.
+func (t *lpmTrie) observeSwap(start time.Time, swapped *bool) {
+	if *swapped {
+		t.observer.ObserveUpdate(true, time.Since(start))
+	}
+}
+
+// observeStore observes a store of LoadOrStore as an insert.
+func (t *lpmTrie) observeStore(start time.Time, loaded *bool) {
+	if !*loaded {
+		t.observer.ObserveUpdate(false, time.Since(start))
+	}
+}
+
+func (t *lpmTrie) observeDelete(start time.Time, hit *bool) {
+	t.observer.ObserveDelete(*hit, time.Since(start))
+}
@@ -0,0 +1,81 @@
+package lpmtrie
+
+import (
+	"testing"
+	"time"
+)
+
+type countingObserver struct {
+	lookups, updates, deletes [2]int
+	latency                   time.Duration
+}
+
+func (o *countingObserver) count(counts *[2]int, result bool, latency time.Duration) {
+	if result {
+		counts[1]++
+	} else {
+		counts[0]++
+	}
+	o.latency += latency
+}
+
+func (o *countingObserver) ObserveLookup(hit bool, latency time.Duration) {
+	o.count(&o.lookups, hit, latency)
+}
+
+func (o *countingObserver) ObserveUpdate(replaced bool, latency time.Duration) {
+	o.count(&o.updates, replaced, latency)
+}
+
+func (o *countingObserver) ObserveDelete(hit bool, latency time.Duration) {
+	o.count(&o.deletes, hit, latency)
+}
+
+func TestObserver(t *testing.T) {
+	const plen = 32
+
+	for _, opts := range [][]Option{nil, {WithBackend(BackendStride)}, {WithArena()}} {
+		var o countingObserver
+		trie, _ := New(plen, append(opts, WithObserver(&o))...)
+
+		trie.Update(Key{8, []byte{10, 0, 0, 0}}, 1)
+		trie.Update(Key{8, []byte{10, 0, 0, 0}}, 2)
+		trie.Update(Key{16, []byte{10, 1, 0, 0}}, 3)
+		if o.updates != [2]int{2, 1} {
+			t.Errorf("expected 2 inserts and 1 replace, got %v", o.updates)
+		}
+
+		trie.Lookup(Key{plen, []byte{10, 1, 0, 1}})
+		trie.Lookup(Key{plen, []byte{11, 1, 0, 1}})
+		trie.LookupBatch([]Key{
+			{plen, []byte{10, 2, 0, 1}},
+			{plen, []byte{12, 2, 0, 1}},
+			{plen, []byte{13, 2, 0, 1}},
+		}, make([]interface{}, 3), make([]bool, 3))
+		if o.lookups != [2]int{3, 2} {
+			t.Errorf("expected 2 hits and 3 misses, got %v", o.lookups)
+		}
+
+		trie.Delete(Key{16, []byte{10, 1, 0, 0}})
+		trie.Delete(Key{16, []byte{10, 1, 0, 0}})
+		if o.deletes != [2]int{1, 1} {
+			t.Errorf("expected 1 hit and 1 miss, got %v", o.deletes)
+		}
+
+		if o.latency <= 0 {
+			t.Errorf("expected latencies to be observed")
+		}
+	}
+}
+
+func TestObserverAllocs(t *testing.T) {
+	trie, _ := New(32, WithObserver(NewPrometheusObserver("lpmtrie")))
+	trie.Update(Key{8, []byte{10, 0, 0, 0}}, 1)
+
+	key := Key{32, []byte{10, 0, 0, 1}}
+	if n := testing.AllocsPerRun(100, func() {
+		trie.Lookup(key)
+	}); n != 0 {
+		t.Errorf("expected observed lookup not to allocate, got %v", n)
+	}
+}
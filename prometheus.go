@@ -0,0 +1,94 @@
+package lpmtrie
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// PrometheusObserver is an Observer counting the operations of tries, and
+// exposes the counters in the Prometheus text exposition format by serving
+// HTTP. The metrics are named after the prefix, like:
+//
+//	<prefix>_lookups_total{result="hit|miss"}
+//	<prefix>_lookup_duration_seconds_sum
+//	<prefix>_lookup_duration_seconds_count
+//	<prefix>_updates_total{result="insert|replace"}
+//	<prefix>_update_duration_seconds_sum
+//	<prefix>_update_duration_seconds_count
+//	<prefix>_deletes_total{result="hit|miss"}
+//	<prefix>_delete_duration_seconds_sum
+//	<prefix>_delete_duration_seconds_count
+type PrometheusObserver struct {
+	prefix  string
+	lookups opMetrics
+	updates opMetrics
+	deletes opMetrics
+}
+
+// opMetrics counts an operation by its result, false or true.
+type opMetrics struct {
+	results [2]atomic.Uint64
+	nanos   atomic.Int64
+}
+
+func (m *opMetrics) observe(result bool, latency time.Duration) {
+	if result {
+		m.results[1].Add(1)
+	} else {
+		m.results[0].Add(1)
+	}
+	m.nanos.Add(int64(latency))
+}
+
+var _ Observer = (*PrometheusObserver)(nil)
+var _ http.Handler = (*PrometheusObserver)(nil)
+
+// NewPrometheusObserver creates a PrometheusObserver naming its metrics after
+// prefix, which must be a valid Prometheus metric name like "lpmtrie".
+func NewPrometheusObserver(prefix string) *PrometheusObserver {
+	return &PrometheusObserver{prefix: prefix}
+}
+
+func (p *PrometheusObserver) ObserveLookup(hit bool, latency time.Duration) {
+	p.lookups.observe(hit, latency)
+}
+
+func (p *PrometheusObserver) ObserveUpdate(replaced bool, latency time.Duration) {
+	p.updates.observe(replaced, latency)
+}
+
+func (p *PrometheusObserver) ObserveDelete(hit bool, latency time.Duration) {
+	p.deletes.observe(hit, latency)
+}
+
+func (p *PrometheusObserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	bw := bufio.NewWriter(w)
+	p.write(bw, "lookup", "lookups", [2]string{"miss", "hit"}, &p.lookups)
+	p.write(bw, "update", "updates", [2]string{"insert", "replace"}, &p.updates)
+	p.write(bw, "delete", "deletes", [2]string{"miss", "hit"}, &p.deletes)
+	_ = bw.Flush()
+}
+
+func (p *PrometheusObserver) write(w *bufio.Writer, op, ops string, results [2]string, m *opMetrics) {
+	// Load the latency before the counters, since observe adds to the
+	// counters first: every latency in the sum is counted, so the average
+	// latency is never over-estimated.
+	nanos := m.nanos.Load()
+	counts := [2]uint64{m.results[0].Load(), m.results[1].Load()}
+
+	fmt.Fprintf(w, "# HELP %s_%s_total Number of %s of the trie by result.\n", p.prefix, ops, ops)
+	fmt.Fprintf(w, "# TYPE %s_%s_total counter\n", p.prefix, ops)
+	for i, result := range results {
+		fmt.Fprintf(w, "%s_%s_total{result=%q} %d\n", p.prefix, ops, result, counts[i])
+	}
+
+	fmt.Fprintf(w, "# HELP %s_%s_duration_seconds Latency of %s of the trie.\n", p.prefix, op, ops)
+	fmt.Fprintf(w, "# TYPE %s_%s_duration_seconds summary\n", p.prefix, op)
+	fmt.Fprintf(w, "%s_%s_duration_seconds_sum %g\n", p.prefix, op, time.Duration(nanos).Seconds())
+	fmt.Fprintf(w, "%s_%s_duration_seconds_count %d\n", p.prefix, op, counts[0]+counts[1])
+}
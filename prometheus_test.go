@@ -0,0 +1,55 @@
+package lpmtrie
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusObserver(t *testing.T) {
+	const plen = 32
+
+	o := NewPrometheusObserver("routes")
+	trie, _ := New(plen, WithObserver(o))
+
+	trie.Update(Key{8, []byte{10, 0, 0, 0}}, 1)
+	trie.Update(Key{8, []byte{10, 0, 0, 0}}, 2)
+	trie.Lookup(Key{plen, []byte{10, 0, 0, 1}})
+	trie.Lookup(Key{plen, []byte{10, 0, 0, 2}})
+	trie.Lookup(Key{plen, []byte{11, 0, 0, 1}})
+	trie.Delete(Key{16, []byte{10, 1, 0, 0}})
+
+	srv := httptest.NewServer(o)
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	text := string(body)
+	for _, line := range []string{
+		"# TYPE routes_lookups_total counter",
+		`routes_lookups_total{result="hit"} 2`,
+		`routes_lookups_total{result="miss"} 1`,
+		"# TYPE routes_lookup_duration_seconds summary",
+		"routes_lookup_duration_seconds_count 3",
+		`routes_updates_total{result="insert"} 1`,
+		`routes_updates_total{result="replace"} 1`,
+		"routes_update_duration_seconds_count 2",
+		`routes_deletes_total{result="hit"} 0`,
+		`routes_deletes_total{result="miss"} 1`,
+		"routes_delete_duration_seconds_sum ",
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("expected line %q in:\n%s", line, text)
+		}
+	}
+}
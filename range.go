@@ -0,0 +1,153 @@
+package lpmtrie
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrInvalidRange is returned when the start of an address range is greater
+// than its end.
+var ErrInvalidRange = errors.New("lpmtrie: start of range is greater than its end")
+
+// Bounds returns the first and the last address covered by the key.
+// The bits of key's data after the prefix length are ignored.
+func (k Key) Bounds() (first, last []byte) {
+	first = make([]byte, len(k.Data))
+	copy(first, k.Data)
+	clearHostBits(first, k.PrefixLen)
+
+	last = make([]byte, len(k.Data))
+	copy(last, first)
+	setHostBits(last, k.PrefixLen)
+	return first, last
+}
+
+// RangeToPrefixes decomposes the inclusive address range [start, end] into
+// the minimal set of prefixes covering it, in ascending order.
+// The lengths of start and end must be same.
+func RangeToPrefixes(start, end []byte) ([]Key, error) {
+	if len(start) != len(end) {
+		return nil, errors.New("lpmtrie: start and end of range must have same length")
+	}
+
+	return rangeToPrefixes(start, end, len(start)*8)
+}
+
+// rangeToPrefixes decomposes the range of addresses of the first bits bits
+// of start and end. The bits of cur after the address are kept cleared, and
+// the ones of last and end are kept set, which doesn't change how they
+// compare.
+func rangeToPrefixes(start, end []byte, bits int) ([]Key, error) {
+	cur := make([]byte, len(start))
+	copy(cur, start)
+	clearHostBits(cur, bits)
+
+	last := make([]byte, len(end))
+	copy(last, end)
+	setHostBits(last, bits)
+	end = last
+
+	if bytes.Compare(cur, end) > 0 {
+		return nil, ErrInvalidRange
+	}
+
+	// the number of bits of the last byte after the address
+	pad := len(start)*8 - bits
+
+	var keys []Key
+	last = make([]byte, len(start))
+	for {
+		prefixLen := bits - (trailingZeros(cur) - pad)
+		for ; prefixLen < bits; prefixLen++ {
+			copy(last, cur)
+			setHostBits(last, prefixLen)
+			if bytes.Compare(last, end) <= 0 {
+				break
+			}
+		}
+
+		data := make([]byte, len(cur))
+		copy(data, cur)
+		keys = append(keys, Key{PrefixLen: prefixLen, Data: data})
+
+		copy(last, cur)
+		setHostBits(last, prefixLen)
+		if bytes.Equal(last, end) {
+			return keys, nil
+		}
+
+		copy(cur, last)
+		increment(cur)
+	}
+}
+
+func (t *lpmTrie) InsertRange(start, end []byte, val interface{}) error {
+	t.checkKey(Key{PrefixLen: t.maxPrefixLen, Data: start})
+	t.checkKey(Key{PrefixLen: t.maxPrefixLen, Data: end})
+
+	keys, err := rangeToPrefixes(start, end, t.maxPrefixLen)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, key := range keys {
+		t.update(key, val)
+	}
+	return nil
+}
+
+// clearHostBits clears the bits of data after the first prefixLen ones.
+func clearHostBits(data []byte, prefixLen int) {
+	for i := range data {
+		switch {
+		case prefixLen >= (i+1)*8:
+		case prefixLen <= i*8:
+			data[i] = 0
+		default:
+			data[i] &= 0xff << (8 - prefixLen%8)
+		}
+	}
+}
+
+// setHostBits sets the bits of data after the first prefixLen ones.
+func setHostBits(data []byte, prefixLen int) {
+	for i := range data {
+		switch {
+		case prefixLen >= (i+1)*8:
+		case prefixLen <= i*8:
+			data[i] = 0xff
+		default:
+			data[i] |= 0xff >> (prefixLen % 8)
+		}
+	}
+}
+
+// trailingZeros returns the number of trailing zero bits of data.
+func trailingZeros(data []byte) int {
+	n := 0
+	for i := len(data) - 1; i >= 0; i-- {
+		if data[i] != 0 {
+			for b := data[i]; b&1 == 0; b >>= 1 {
+				n++
+			}
+			return n
+		}
+		n += 8
+	}
+	return n
+}
+
+// increment adds one to data as a big-endian number, and reports whether it
+// wrapped around.
+func increment(data []byte) (overflow bool) {
+	for i := len(data) - 1; i >= 0; i-- {
+		data[i]++
+		if data[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,248 @@
+package lpmtrie
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestBounds(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   Key
+		first []byte
+		last  []byte
+	}{
+		{
+			"host",
+			Key{32, []byte{10, 1, 2, 3}},
+			[]byte{10, 1, 2, 3},
+			[]byte{10, 1, 2, 3},
+		},
+		{
+			"byte aligned",
+			Key{16, []byte{10, 1, 2, 3}},
+			[]byte{10, 1, 0, 0},
+			[]byte{10, 1, 255, 255},
+		},
+		{
+			"not byte aligned",
+			Key{20, []byte{10, 1, 0b10110101, 3}},
+			[]byte{10, 1, 0b10110000, 0},
+			[]byte{10, 1, 0b10111111, 255},
+		},
+		{
+			"default",
+			Key{0, []byte{10, 1, 2, 3}},
+			[]byte{0, 0, 0, 0},
+			[]byte{255, 255, 255, 255},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, last := tt.key.Bounds()
+			if !bytes.Equal(first, tt.first) {
+				t.Errorf("expected first to be %v, got %v", tt.first, first)
+			}
+			if !bytes.Equal(last, tt.last) {
+				t.Errorf("expected last to be %v, got %v", tt.last, last)
+			}
+		})
+	}
+}
+
+func TestRangeToPrefixes(t *testing.T) {
+	tests := []struct {
+		name   string
+		start  []byte
+		end    []byte
+		expect []Key
+	}{
+		{
+			"single address",
+			[]byte{10, 0, 0, 1},
+			[]byte{10, 0, 0, 1},
+			[]Key{{32, []byte{10, 0, 0, 1}}},
+		},
+		{
+			"aligned block",
+			[]byte{10, 0, 0, 0},
+			[]byte{10, 0, 255, 255},
+			[]Key{{16, []byte{10, 0, 0, 0}}},
+		},
+		{
+			"whole space",
+			[]byte{0, 0},
+			[]byte{255, 255},
+			[]Key{{0, []byte{0, 0}}},
+		},
+		{
+			"unaligned",
+			[]byte{10, 0, 0, 1},
+			[]byte{10, 0, 0, 6},
+			[]Key{
+				{32, []byte{10, 0, 0, 1}},
+				{31, []byte{10, 0, 0, 2}},
+				{31, []byte{10, 0, 0, 4}},
+				{32, []byte{10, 0, 0, 6}},
+			},
+		},
+		{
+			"across bytes",
+			[]byte{10, 0, 0, 255},
+			[]byte{10, 0, 1, 0},
+			[]Key{
+				{32, []byte{10, 0, 0, 255}},
+				{32, []byte{10, 0, 1, 0}},
+			},
+		},
+		{
+			"up to the last address",
+			[]byte{255, 128},
+			[]byte{255, 255},
+			[]Key{{9, []byte{255, 128}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := RangeToPrefixes(tt.start, tt.end)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(keys) != len(tt.expect) {
+				t.Fatalf("expected %d prefixes, got %v", len(tt.expect), keys)
+			}
+			for i := range keys {
+				if keys[i].PrefixLen != tt.expect[i].PrefixLen || !bytes.Equal(keys[i].Data, tt.expect[i].Data) {
+					t.Errorf("expected prefix %d to be %v, got %v", i, tt.expect[i], keys[i])
+				}
+			}
+		})
+	}
+
+	if _, err := RangeToPrefixes([]byte{10, 0, 0, 2}, []byte{10, 0, 0, 1}); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("expected ErrInvalidRange, got %v", err)
+	}
+	if _, err := RangeToPrefixes([]byte{10, 0, 0, 2}, []byte{10, 0, 1}); err == nil {
+		t.Errorf("expected error of different lengths")
+	}
+}
+
+func TestInsertRange(t *testing.T) {
+	const plen = 32
+	var trie *lpmTrie
+
+	reset := func() {
+		lt, _ := New(plen)
+		trie = lt.(*lpmTrie)
+	}
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{
+			"unaligned range",
+			func(t *testing.T) {
+				err := trie.InsertRange([]byte{10, 0, 0, 10}, []byte{10, 0, 1, 20}, 1)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				for _, data := range [][]byte{{10, 0, 0, 10}, {10, 0, 0, 255}, {10, 0, 1, 20}} {
+					v, ok := trie.Lookup(Key{plen, data})
+					if !ok || v.(int) != 1 {
+						t.Errorf("expected lookup of %v to succeed", data)
+					}
+				}
+
+				for _, data := range [][]byte{{10, 0, 0, 9}, {10, 0, 1, 21}} {
+					if _, ok := trie.Lookup(Key{plen, data}); ok {
+						t.Errorf("expected lookup of %v to fail", data)
+					}
+				}
+			},
+		},
+		{
+			"invalid range",
+			func(t *testing.T) {
+				err := trie.InsertRange([]byte{10, 0, 0, 10}, []byte{10, 0, 0, 9}, 1)
+				if !errors.Is(err, ErrInvalidRange) {
+					t.Errorf("expected ErrInvalidRange, got %v", err)
+				}
+				if trie.Size() != 0 {
+					t.Errorf("expected size to be 0")
+				}
+			},
+		},
+		{
+			"bounds of inserted prefixes",
+			func(t *testing.T) {
+				start, end := []byte{192, 168, 0, 100}, []byte{192, 168, 3, 7}
+				_ = trie.InsertRange(start, end, 1)
+
+				var prev []byte
+				trie.Range(func(key Key, val interface{}) bool {
+					first, last := key.Bounds()
+					if prev == nil {
+						if !bytes.Equal(first, start) {
+							t.Errorf("expected first address to be %v, got %v", start, first)
+						}
+					} else {
+						increment(prev)
+						if !bytes.Equal(first, prev) {
+							t.Errorf("expected prefixes to be contiguous at %v, got %v", prev, first)
+						}
+					}
+					prev = last
+					return true
+				})
+				if !bytes.Equal(prev, end) {
+					t.Errorf("expected last address to be %v, got %v", end, prev)
+				}
+			},
+		},
+		{
+			"odd width",
+			func(t *testing.T) {
+				// 20-bit MPLS labels, with garbage after the 20 bits.
+				lt, _ := New(20)
+				trie = lt.(*lpmTrie)
+
+				err := trie.InsertRange([]byte{0x00, 0x01, 0x0f}, []byte{0x00, 0x02, 0x1f}, 1)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				var keys []Key
+				trie.Range(func(key Key, val interface{}) bool {
+					keys = append(keys, key)
+					return true
+				})
+				expectKeys(t, keys, []Key{
+					{16, []byte{0x00, 0x01, 0x00}},
+					{19, []byte{0x00, 0x02, 0x00}},
+				})
+
+				err = trie.InsertRange([]byte{0x00, 0x03, 0x1f}, []byte{0x00, 0x03, 0x0f}, 1)
+				if !errors.Is(err, ErrInvalidRange) {
+					t.Errorf("expected ErrInvalidRange, got %v", err)
+				}
+
+				_ = trie.InsertRange([]byte{0x00, 0x00, 0x00}, []byte{0xff, 0xff, 0xf0}, 2)
+				if trie.Size() != 3 {
+					t.Errorf("expected the whole space to be 1 prefix, got size %d", trie.Size())
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reset()
+			tt.run(t)
+		})
+	}
+}
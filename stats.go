@@ -0,0 +1,92 @@
+package lpmtrie
+
+import "unsafe"
+
+// Stats is the statistics of the structure of a trie.
+type Stats struct {
+	// Entries is the number of key-value pairs.
+	Entries int64
+	// IntermediateNodes is the number of nodes created by Update to branch,
+	// which hold no value.
+	IntermediateNodes int64
+	// Nodes is the number of all nodes, Entries plus IntermediateNodes.
+	Nodes int64
+
+	// MaxDepth is the number of nodes on the longest path from the root,
+	// and AvgDepth is the average number of nodes on the paths from the root
+	// to the entries, which is the number of nodes visited by a Lookup
+	// matching the entry.
+	MaxDepth int
+	AvgDepth float64
+
+	// PrefixLens is the histogram of prefix lengths of the entries, of which
+	// PrefixLens[n] is the number of entries of n bits prefix.
+	PrefixLens []int64
+
+	// Bytes is the estimated memory used by the trie, excluding the values.
+	Bytes int64
+}
+
+func (t *lpmTrie) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st := Stats{PrefixLens: make([]int64, t.maxPrefixLen+1)}
+
+	var depths int64
+	t.walkStats(t.rootNode(), 1, &st, &depths)
+	if st.Entries != 0 {
+		st.AvgDepth = float64(depths) / float64(st.Entries)
+	}
+
+	if t.arena != nil {
+		st.Bytes += int64(len(*t.arena.slabs.Load())) * int64(unsafe.Sizeof(arenaSlab{}))
+	} else {
+		st.Bytes += st.Nodes * int64(unsafe.Sizeof(lpmTrieNode{}))
+	}
+	if t.keySize > wordsSize {
+		st.Bytes += st.Nodes * int64(t.keySize-wordsSize)
+	}
+	if t.stride != nil {
+		st.Bytes += t.stride.root.bytes()
+	}
+
+	return st
+}
+
+func (t *lpmTrie) walkStats(node *lpmTrieNode, depth int, st *Stats, depths *int64) {
+	if node == nil {
+		return
+	}
+
+	st.Nodes++
+	if depth > st.MaxDepth {
+		st.MaxDepth = depth
+	}
+
+	if node.isIm() {
+		st.IntermediateNodes++
+	} else {
+		st.Entries++
+		st.PrefixLens[node.PrefixLen]++
+		*depths += int64(depth)
+
+		if node.value.Load() != &node.val {
+			st.Bytes += int64(unsafe.Sizeof(nodeValue{}))
+		}
+	}
+
+	t.walkStats(t.childNode(node, 0), depth+1, st, depths)
+	t.walkStats(t.childNode(node, 1), depth+1, st, depths)
+}
+
+// bytes returns the estimated memory used by the stride nodes under n.
+func (n *strideNode) bytes() int64 {
+	size := int64(unsafe.Sizeof(*n)) + int64(len(n.path))
+	for i := range n.slots {
+		if child := loadStrideNode(&n.slots[i].child); child != nil {
+			size += child.bytes()
+		}
+	}
+	return size
+}
@@ -0,0 +1,85 @@
+package lpmtrie
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestStats(t *testing.T) {
+	const plen = 32
+
+	trie, _ := New(plen)
+	if st := trie.Stats(); st.Nodes != 0 || st.MaxDepth != 0 || st.AvgDepth != 0 || st.Bytes != 0 {
+		t.Errorf("expected stats of empty trie to be zero, got %+v", st)
+	}
+
+	trie.Update(Key{16, []byte{10, 1, 0, 0}}, 1)
+	trie.Update(Key{16, []byte{10, 2, 0, 0}}, 2)
+	nodeSize := int64(unsafe.Sizeof(lpmTrieNode{}))
+
+	st := trie.Stats()
+	if st.Entries != 2 || st.IntermediateNodes != 1 || st.Nodes != 3 {
+		t.Errorf("expected 2 entries and 1 intermediate node, got %+v", st)
+	}
+	if st.MaxDepth != 2 || st.AvgDepth != 2 {
+		t.Errorf("expected depth to be 2, got max %d, avg %v", st.MaxDepth, st.AvgDepth)
+	}
+	if len(st.PrefixLens) != plen+1 || st.PrefixLens[16] != 2 {
+		t.Errorf("expected 2 entries of prefix length 16, got %v", st.PrefixLens)
+	}
+	if st.Bytes != 3*nodeSize {
+		t.Errorf("expected bytes to be %d, got %d", 3*nodeSize, st.Bytes)
+	}
+
+	trie.Update(Key{8, []byte{10, 0, 0, 0}}, 8)
+	trie.Update(Key{16, []byte{10, 1, 0, 0}}, 11)
+
+	st = trie.Stats()
+	if st.Entries != 3 || st.Nodes != 4 || st.PrefixLens[8] != 1 {
+		t.Errorf("expected 3 entries in 4 nodes, got %+v", st)
+	}
+	if st.MaxDepth != 3 || st.AvgDepth != 7.0/3 {
+		t.Errorf("expected max depth 3 and avg depth 7/3, got max %d, avg %v", st.MaxDepth, st.AvgDepth)
+	}
+	if expect := 4*nodeSize + int64(unsafe.Sizeof(nodeValue{})); st.Bytes != expect {
+		t.Errorf("expected bytes to be %d with the replaced value, got %d", expect, st.Bytes)
+	}
+}
+
+func TestStatsBackends(t *testing.T) {
+	for _, plen := range []int{MaxPrefixLenIPv4, MaxPrefixLenIPv6} {
+		table := SyntheticTable(1, 10000, plen)
+
+		var binary Stats
+		for _, c := range testBackends {
+			trie, _ := New(plen, c.opts...)
+			for i, key := range table {
+				trie.Update(key, i)
+			}
+
+			st := trie.Stats()
+			if st.Entries != trie.Size() || st.Nodes != st.Entries+st.IntermediateNodes {
+				t.Errorf("%s: expected entries to be %d, got %+v", c.name, trie.Size(), st)
+			}
+
+			var sum int64
+			for _, n := range st.PrefixLens {
+				sum += n
+			}
+			if sum != st.Entries {
+				t.Errorf("%s: expected histogram to sum up to %d, got %d", c.name, st.Entries, sum)
+			}
+
+			if c.name == "binary" {
+				binary = st
+				continue
+			}
+			if st.Nodes != binary.Nodes || st.MaxDepth != binary.MaxDepth {
+				t.Errorf("%s: expected the same structure as binary, got %+v", c.name, st)
+			}
+			if st.Bytes <= binary.Bytes {
+				t.Errorf("%s: expected more bytes than binary %d, got %d", c.name, binary.Bytes, st.Bytes)
+			}
+		}
+	}
+}
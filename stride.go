@@ -0,0 +1,203 @@
+package lpmtrie
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+// strideBits is the number of key bits looked up by each stride node.
+const strideBits = 4
+
+// strideTable is a path-compressed multibit trie mirroring the entries of the
+// binary trie, used by BackendStride to look up keys.
+//
+// A node at level l looks up the key bits [l*strideBits, (l+1)*strideBits),
+// and each of its slots holds the longest entry whose prefix length falls in
+// these bits and whose prefix covers the slot. Nodes are only created where
+// they hold entries or branch, so a node keeps the key bits before its level
+// to verify the levels skipped above it.
+type strideTable struct {
+	root *strideNode
+}
+
+type strideNode struct {
+	level int
+	path  []byte
+	slots [1 << strideBits]strideSlot
+}
+
+type strideSlot struct {
+	leaf  atomic.Pointer[lpmTrieNode]
+	child atomic.Pointer[strideNode]
+}
+
+func newStrideTable(keySize int) *strideTable {
+	return &strideTable{root: newStrideNode(make([]byte, keySize), 0)}
+}
+
+func newStrideNode(data []byte, level int) *strideNode {
+	var n strideNode
+	n.level = level
+	n.path = make([]byte, len(data))
+	copy(n.path, data)
+	clearHostBits(n.path, level*strideBits)
+	return &n
+}
+
+func loadStrideNode(ptr *atomic.Pointer[strideNode]) *strideNode {
+	return ptr.Load()
+}
+
+func storeStrideNode(ptr *atomic.Pointer[strideNode], node *strideNode) {
+	ptr.Store(node)
+}
+
+// strideLevel returns the level holding the entries of prefixLen bits.
+func strideLevel(prefixLen int) int {
+	if prefixLen == 0 {
+		return 0
+	}
+	return (prefixLen - 1) / strideBits
+}
+
+func strideIndex(data []byte, level int) int {
+	b := data[level*strideBits/8]
+	if level*strideBits%8 == 0 {
+		return int(b >> 4)
+	}
+	return int(b & 0x0f)
+}
+
+func setStrideIndex(data []byte, level, idx int) {
+	i := level * strideBits / 8
+	if level*strideBits%8 == 0 {
+		data[i] = data[i]&0x0f | byte(idx)<<4
+	} else {
+		data[i] = data[i]&0xf0 | byte(idx)
+	}
+}
+
+// commonPrefixLen returns the number of leading bits shared by a and b, up to
+// limit.
+func commonPrefixLen(a, b []byte, limit int) int {
+	n := 0
+	for i := 0; n < limit; i++ {
+		diff := a[i] ^ b[i]
+		if diff != 0 {
+			return min(n+bits.LeadingZeros8(diff), limit)
+		}
+		n += 8
+	}
+	return limit
+}
+
+func (s *strideTable) lookup(key Key) (interface{}, bool) {
+	var found *lpmTrieNode
+
+	for node := s.root; node != nil; {
+		slot := &node.slots[strideIndex(key.Data, node.level)]
+		if leaf := loadPointer(&slot.leaf); leaf != nil {
+			found = leaf
+		}
+
+		next := loadStrideNode(&slot.child)
+		if next != nil && next.level != node.level+1 {
+			// Verify the skipped levels.
+			pathlen := next.level * strideBits
+			if commonPrefixLen(next.path, key.Data, pathlen) != pathlen {
+				break
+			}
+		}
+		node = next
+	}
+
+	if found == nil {
+		return nil, false
+	}
+
+	return found.loadValue(), true
+}
+
+// refresh recomputes the slots covered by key after the entry of key has
+// been updated or deleted in the binary trie. It must be called with the
+// writer lock held.
+func (s *strideTable) refresh(t *lpmTrie, key Key) {
+	level := strideLevel(key.PrefixLen)
+	path := s.walk(key.Data, level)
+	node := path[len(path)-1]
+
+	begin, end := level*strideBits, min((level+1)*strideBits, t.maxPrefixLen)
+	span := 1 << ((level+1)*strideBits - key.PrefixLen)
+	first := strideIndex(key.Data, level) &^ (span - 1)
+
+	prefix := Key{PrefixLen: end, Data: make([]byte, t.keySize)}
+	copy(prefix.Data, key.Data)
+	clearHostBits(prefix.Data, begin)
+
+	for idx := first; idx < first+span; idx++ {
+		setStrideIndex(prefix.Data, level, idx)
+
+		var leaf *lpmTrieNode
+		t.supernets(prefix, func(n *lpmTrieNode) bool {
+			if level == 0 || n.PrefixLen > begin {
+				leaf = n
+			}
+			return true
+		})
+		storePointer(&node.slots[idx].leaf, leaf)
+	}
+
+	s.prune(path)
+}
+
+// walk returns the nodes from the root to the node at level on the path of
+// data, creating the missing ones.
+func (s *strideTable) walk(data []byte, level int) []*strideNode {
+	path := []*strideNode{s.root}
+
+	for node := s.root; node.level < level; {
+		slot := &node.slots[strideIndex(data, node.level)]
+		child := loadStrideNode(&slot.child)
+		if child == nil {
+			child = newStrideNode(data, level)
+			storeStrideNode(&slot.child, child)
+		} else if matched := commonPrefixLen(child.path, data, min(child.level, level)*strideBits) / strideBits; matched < child.level {
+			// Split the compressed path above child.
+			mid := newStrideNode(data, matched)
+			storeStrideNode(&mid.slots[strideIndex(child.path, matched)].child, child)
+			storeStrideNode(&slot.child, mid)
+			child = mid
+		}
+
+		path = append(path, child)
+		node = child
+	}
+
+	return path
+}
+
+// prune removes the nodes on path holding no entry, from the bottom up. A
+// node left with a single child is replaced by the child.
+func (s *strideTable) prune(path []*strideNode) {
+	for i := len(path) - 1; i > 0; i-- {
+		node := path[i]
+
+		var child *strideNode
+		children := 0
+		for j := range node.slots {
+			if loadPointer(&node.slots[j].leaf) != nil {
+				return
+			}
+			if c := loadStrideNode(&node.slots[j].child); c != nil {
+				child = c
+				children++
+			}
+		}
+		if children > 1 {
+			return
+		}
+
+		parent := path[i-1]
+		storeStrideNode(&parent.slots[strideIndex(node.path, parent.level)].child, child)
+	}
+}
@@ -0,0 +1,97 @@
+package lpmtrie
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// randomKeys generates n keys of random prefix lengths under the first byte
+// 10, so that many of them share prefixes.
+func randomKeys(rng *rand.Rand, n, maxPrefixLen int) []Key {
+	keys := make([]Key, n)
+	for i := range keys {
+		data := make([]byte, (maxPrefixLen+7)/8)
+		rng.Read(data)
+		data[0] = 10
+		keys[i] = Key{PrefixLen: rng.Intn(maxPrefixLen + 1), Data: data}
+	}
+	return keys
+}
+
+func TestStrideBackend(t *testing.T) {
+	for _, plen := range []int{8, 12, 16, 20, 32, 48, 128, 130, 256} {
+		t.Run(fmt.Sprintf("max prefix length %d", plen), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(int64(plen)))
+
+			lt, _ := New(plen)
+			binary := lt.(*lpmTrie)
+			lt, _ = New(plen, WithBackend(BackendStride))
+			stride := lt.(*lpmTrie)
+
+			check := func(keys []Key) {
+				t.Helper()
+
+				for _, key := range keys {
+					host := Key{PrefixLen: plen, Data: key.Data}
+					v1, ok1 := binary.Lookup(host)
+					v2, ok2 := stride.Lookup(host)
+					if ok1 != ok2 || v1 != v2 {
+						t.Fatalf("lookup of %v: binary got %v %v, stride got %v %v", host, v1, ok1, v2, ok2)
+					}
+				}
+			}
+
+			keys := randomKeys(rng, 1000, plen)
+			for i, key := range keys {
+				binary.Update(key, i)
+				stride.Update(key, i)
+			}
+			check(keys)
+			check(randomKeys(rng, 1000, plen))
+
+			for _, key := range keys[:500] {
+				if binary.Delete(key) != stride.Delete(key) {
+					t.Fatalf("delete of %v differs", key)
+				}
+			}
+			check(keys)
+			check(randomKeys(rng, 1000, plen))
+
+			for _, key := range keys[500:] {
+				stride.Delete(key)
+			}
+			for i := range stride.stride.root.slots {
+				slot := &stride.stride.root.slots[i]
+				if loadPointer(&slot.leaf) != nil || loadStrideNode(&slot.child) != nil {
+					t.Errorf("expected slot %d of root to be empty", i)
+				}
+			}
+		})
+	}
+}
+
+func TestStrideBackendPrefixKey(t *testing.T) {
+	const plen = 32
+
+	lt, _ := New(plen, WithBackend(BackendStride))
+	trie := lt.(*lpmTrie)
+	trie.Update(Key{24, []byte{10, 1, 1, 0}}, 24)
+	trie.Update(Key{16, []byte{10, 1, 0, 0}}, 16)
+
+	v, ok := trie.Lookup(Key{20, []byte{10, 1, 1, 0}})
+	if !ok || v.(int) != 16 {
+		t.Errorf("expected lookup of shorter key to match 16, got %v", v)
+	}
+
+	v, ok = trie.Lookup(Key{plen, []byte{10, 1, 1, 1}})
+	if !ok || v.(int) != 24 {
+		t.Errorf("expected lookup to match 24, got %v", v)
+	}
+}
+
+func TestUnknownBackend(t *testing.T) {
+	if _, err := New(32, WithBackend(Backend(-1))); err == nil {
+		t.Errorf("expected unknown backend to fail")
+	}
+}
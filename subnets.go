@@ -0,0 +1,56 @@
+package lpmtrie
+
+func (t *lpmTrie) Supernets(prefix Key, fn func(key Key, val interface{}) bool) {
+	t.checkKey(prefix)
+
+	defer t.exit(t.enter())
+
+	t.supernets(prefix, func(node *lpmTrieNode) bool {
+		if t.expired(node) {
+			return true
+		}
+		return fn(t.keyOf(&node.keyBits), node.loadValue())
+	})
+}
+
+func (t *lpmTrie) supernets(prefix Key, fn func(node *lpmTrieNode) bool) {
+	k := t.bitsOf(prefix)
+	for node := t.rootNode(); node != nil && node.PrefixLen <= k.PrefixLen; node = t.childNode(node, k.bit(node.PrefixLen)) {
+		if t.longestPrefixMatch(node, k) < node.PrefixLen {
+			return
+		}
+
+		if !node.isIm() && !fn(node) {
+			return
+		}
+
+		if node.PrefixLen == prefix.PrefixLen {
+			return
+		}
+	}
+}
+
+func (t *lpmTrie) HasSubnets(prefix Key) bool {
+	t.checkKey(prefix)
+
+	defer t.exit(t.enter())
+
+	k := t.bitsOf(prefix)
+	for node := t.rootNode(); node != nil; node = t.childNode(node, k.bit(node.PrefixLen)) {
+		matchlen := t.longestPrefixMatch(node, k)
+		if node.PrefixLen > prefix.PrefixLen {
+			// Every subtree holds at least one entry.
+			return matchlen == prefix.PrefixLen
+		}
+
+		if matchlen < node.PrefixLen {
+			return false
+		}
+
+		if node.PrefixLen == prefix.PrefixLen {
+			return t.childNode(node, 0) != nil || t.childNode(node, 1) != nil
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,131 @@
+package lpmtrie
+
+import "testing"
+
+func TestSupernets(t *testing.T) {
+	const plen = 32
+	var trie *lpmTrie
+
+	reset := func() {
+		lt, _ := New(plen)
+		trie = lt.(*lpmTrie)
+		trie.Update(Key{8, []byte{10, 0, 0, 0}}, 8)
+		trie.Update(Key{16, []byte{10, 1, 0, 0}}, 16)
+		trie.Update(Key{24, []byte{10, 1, 1, 0}}, 24)
+		trie.Update(Key{24, []byte{10, 1, 2, 0}}, 124)
+	}
+
+	collect := func(prefix Key) []Key {
+		var keys []Key
+		trie.Supernets(prefix, func(key Key, val interface{}) bool {
+			if val.(int)%100 != key.PrefixLen {
+				t.Errorf("unexpected value %v of key %v", val, key)
+			}
+			keys = append(keys, key)
+			return true
+		})
+		return keys
+	}
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{
+			"shorter prefix",
+			func(t *testing.T) {
+				expectKeys(t, collect(Key{4, []byte{10, 1, 0, 0}}), nil)
+			},
+		},
+		{
+			"same prefix",
+			func(t *testing.T) {
+				expectKeys(t, collect(Key{16, []byte{10, 1, 0, 0}}), []Key{
+					{8, []byte{10, 0, 0, 0}},
+					{16, []byte{10, 1, 0, 0}},
+				})
+			},
+		},
+		{
+			"under intermediate node",
+			func(t *testing.T) {
+				expectKeys(t, collect(Key{20, []byte{10, 1, 0, 0}}), []Key{
+					{8, []byte{10, 0, 0, 0}},
+					{16, []byte{10, 1, 0, 0}},
+				})
+			},
+		},
+		{
+			"longest prefix",
+			func(t *testing.T) {
+				expectKeys(t, collect(Key{plen, []byte{10, 1, 2, 3}}), []Key{
+					{8, []byte{10, 0, 0, 0}},
+					{16, []byte{10, 1, 0, 0}},
+					{24, []byte{10, 1, 2, 0}},
+				})
+			},
+		},
+		{
+			"mismatch",
+			func(t *testing.T) {
+				expectKeys(t, collect(Key{16, []byte{11, 1, 0, 0}}), nil)
+			},
+		},
+		{
+			"terminated",
+			func(t *testing.T) {
+				n := 0
+				trie.Supernets(Key{plen, []byte{10, 1, 2, 3}}, func(key Key, val interface{}) bool {
+					n++
+					return false
+				})
+				if n != 1 {
+					t.Errorf("expected 1 iteration, got %d", n)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reset()
+			tt.run(t)
+		})
+	}
+}
+
+func TestHasSubnets(t *testing.T) {
+	const plen = 32
+
+	lt, _ := New(plen)
+	trie := lt.(*lpmTrie)
+	trie.Update(Key{16, []byte{10, 1, 0, 0}}, 1)
+	trie.Update(Key{24, []byte{10, 1, 1, 0}}, 2)
+	trie.Update(Key{24, []byte{10, 1, 2, 0}}, 3)
+	trie.Update(Key{plen, []byte{10, 2, 0, 1}}, 4)
+
+	tests := []struct {
+		name   string
+		prefix Key
+		expect bool
+	}{
+		{"default", Key{0, []byte{0, 0, 0, 0}}, true},
+		{"less specific", Key{8, []byte{10, 0, 0, 0}}, true},
+		{"entry with subnets", Key{16, []byte{10, 1, 0, 0}}, true},
+		{"intermediate node", Key{22, []byte{10, 1, 0, 0}}, true},
+		{"inside intermediate node", Key{23, []byte{10, 1, 2, 0}}, true},
+		{"leaf", Key{24, []byte{10, 1, 1, 0}}, false},
+		{"host", Key{plen, []byte{10, 2, 0, 1}}, false},
+		{"above host", Key{31, []byte{10, 2, 0, 0}}, true},
+		{"mismatch", Key{16, []byte{10, 3, 0, 0}}, false},
+		{"under leaf", Key{28, []byte{10, 1, 1, 0}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if has := trie.HasSubnets(tt.prefix); has != tt.expect {
+				t.Errorf("expected HasSubnets of %v to be %v, got %v", tt.prefix, tt.expect, has)
+			}
+		})
+	}
+}
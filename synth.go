@@ -0,0 +1,86 @@
+package lpmtrie
+
+import "math/rand"
+
+// prefixLenWeight is the share in permille of the routes of a prefix length.
+type prefixLenWeight struct {
+	prefixLen int
+	permille  int
+}
+
+// The prefix length distributions of the IPv4 and IPv6 full BGP tables,
+// roughly.
+var (
+	bgpIPv4PrefixLens = []prefixLenWeight{
+		{8, 1}, {12, 1}, {13, 2}, {14, 4}, {15, 7}, {16, 14}, {17, 9},
+		{18, 16}, {19, 28}, {20, 42}, {21, 47}, {22, 104}, {23, 92}, {24, 633},
+	}
+	bgpIPv6PrefixLens = []prefixLenWeight{
+		{28, 6}, {29, 60}, {32, 140}, {33, 10}, {34, 10}, {35, 5}, {36, 40},
+		{40, 60}, {44, 80}, {46, 20}, {47, 20}, {48, 519}, {56, 10}, {64, 20},
+	}
+)
+
+// SyntheticTable generates a route table of n distinct prefixes, whose prefix
+// lengths are distributed like the ones of a full BGP table, for benchmarks
+// and tests.
+//
+// Same as real tables, the IPv4 prefixes are in the unicast space 1.0.0.0 to
+// 223.255.255.255, and the IPv6 prefixes are in 2000::/6. The same seed
+// always generates the same table.
+//
+// The maxPrefixLen must be MaxPrefixLenIPv4 or MaxPrefixLenIPv6, or it will
+// panic.
+func SyntheticTable(seed int64, n, maxPrefixLen int) []Key {
+	var weights []prefixLenWeight
+	switch maxPrefixLen {
+	case MaxPrefixLenIPv4:
+		weights = bgpIPv4PrefixLens
+	case MaxPrefixLenIPv6:
+		weights = bgpIPv6PrefixLens
+	default:
+		panic("lpmtrie: synthetic table must be IPv4 or IPv6")
+	}
+
+	type route struct {
+		prefixLen int
+		data      string
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	seen := make(map[route]struct{}, n)
+	keys := make([]Key, 0, n)
+	for len(keys) < n {
+		data := make([]byte, maxPrefixLen/8)
+		rng.Read(data)
+		if maxPrefixLen == MaxPrefixLenIPv4 {
+			data[0] = byte(1 + rng.Intn(223))
+		} else {
+			data[0] = 0x20 | data[0]&0x03
+		}
+
+		prefixLen := pickPrefixLen(rng, weights)
+		clearHostBits(data, prefixLen)
+
+		r := route{prefixLen, string(data)}
+		if _, ok := seen[r]; ok {
+			continue
+		}
+		seen[r] = struct{}{}
+
+		keys = append(keys, Key{PrefixLen: prefixLen, Data: data})
+	}
+
+	return keys
+}
+
+func pickPrefixLen(rng *rand.Rand, weights []prefixLenWeight) int {
+	n := rng.Intn(1000)
+	for _, w := range weights {
+		if n < w.permille {
+			return w.prefixLen
+		}
+		n -= w.permille
+	}
+	return weights[len(weights)-1].prefixLen
+}
@@ -0,0 +1,53 @@
+package lpmtrie
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSyntheticTable(t *testing.T) {
+	for _, plen := range []int{MaxPrefixLenIPv4, MaxPrefixLenIPv6} {
+		const n = 10000
+
+		keys := SyntheticTable(1, n, plen)
+		if len(keys) != n {
+			t.Fatalf("expected %d keys, got %d", n, len(keys))
+		}
+
+		again := SyntheticTable(1, n, plen)
+		for i := range keys {
+			if keys[i].PrefixLen != again[i].PrefixLen || !bytes.Equal(keys[i].Data, again[i].Data) {
+				t.Fatalf("expected the same seed to generate the same table")
+			}
+		}
+
+		seen := make(map[string]bool)
+		lens := make(map[int]int)
+		for _, key := range keys {
+			if first, last := key.Bounds(); !bytes.Equal(first, key.Data) || len(last) != plen/8 {
+				t.Fatalf("expected host bits of %v to be cleared", key)
+			}
+			id := string(rune(key.PrefixLen)) + string(key.Data)
+			if seen[id] {
+				t.Fatalf("expected keys to be distinct, got %v twice", key)
+			}
+			seen[id] = true
+			lens[key.PrefixLen]++
+		}
+
+		common := 24
+		if plen == MaxPrefixLenIPv6 {
+			common = 48
+		}
+		if lens[common] < n/2 {
+			t.Errorf("expected most of the prefixes to be /%d, got %d", common, lens[common])
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected synthetic table of 64 bits to panic")
+		}
+	}()
+	SyntheticTable(1, 1, 64)
+}
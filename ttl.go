@@ -0,0 +1,169 @@
+package lpmtrie
+
+import "time"
+
+// WithClock makes the trie read the current time from now instead of
+// time.Now, to expire the entries updated by UpdateWithTTL.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.clock = now
+	}
+}
+
+func (t *lpmTrie) UpdateWithTTL(key Key, val interface{}, ttl time.Duration) (updated bool) {
+	t.checkKey(key)
+
+	if ttl <= 0 {
+		panic("lpmtrie: ttl must be positive")
+	}
+
+	if t.observer != nil {
+		defer t.observeUpdate(time.Now(), &updated)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.update(key, &expiringValue{v: val, expires: t.now().Add(ttl).UnixNano()})
+}
+
+// expiringValue wraps the value of an entry updated with a TTL, so that the
+// nodes of the other entries don't pay for the expiry.
+type expiringValue struct {
+	v       interface{}
+	expires int64 // in unix nanoseconds
+}
+
+// replaceValue replaces the value of the node in place, keeping its expiry.
+func (n *lpmTrieNode) replaceValue(val interface{}) {
+	if e, ok := n.value.Load().v.(*expiringValue); ok {
+		val = &expiringValue{v: val, expires: e.expires}
+	}
+	n.storeValue(&nodeValue{v: val})
+}
+
+// countExpiring adds delta to the number of entries with a TTL if v is the
+// value of one. The lookups take the fast paths again once it drops to zero.
+func (t *lpmTrie) countExpiring(v interface{}, delta int64) {
+	if _, ok := v.(*expiringValue); ok {
+		t.expiring.Add(delta)
+	}
+}
+
+// expired returns if the node is an entry which has expired.
+func (t *lpmTrie) expired(n *lpmTrieNode) bool {
+	if t.expiring.Load() == 0 {
+		return false
+	}
+
+	e, ok := n.value.Load().v.(*expiringValue)
+	return ok && t.now().UnixNano() >= e.expires
+}
+
+// purge deletes the entry of exactly the key if it has expired, so that
+// writers see it as absent. It must be called with the writer lock held.
+func (t *lpmTrie) purge(key Key) {
+	if t.expiring.Load() == 0 {
+		return
+	}
+
+	expired := false
+	t.supernets(key, func(n *lpmTrieNode) bool {
+		if n.PrefixLen == key.PrefixLen {
+			expired = t.expired(n)
+		}
+		return true
+	})
+	if expired {
+		t.delete(key)
+	}
+}
+
+// reap purges the expired entry of the key found by a reader, unless a
+// writer holds the lock, so that readers never block.
+func (t *lpmTrie) reap(key Key) {
+	if !t.mu.TryLock() {
+		return
+	}
+	defer t.mu.Unlock()
+
+	t.purge(key)
+}
+
+// lookupExpiring is the lookup of the tries having entries with TTL, which
+// skips the expired entries and reaps the longest one of them.
+func (t *lpmTrie) lookupExpiring(key Key) (val interface{}, ok bool) {
+	val, ok, stale := t.lookupLive(key)
+	if stale != nil {
+		t.reap(*stale)
+	}
+	return val, ok
+}
+
+func (t *lpmTrie) lookupLive(key Key) (val interface{}, ok bool, stale *Key) {
+	defer t.exit(t.enter())
+
+	var now int64 // read the clock once at most
+
+	k := t.bitsOf(key)
+	for node := t.rootNode(); node != nil; node = t.childNode(node, k.bit(node.PrefixLen)) {
+		if t.longestPrefixMatch(node, k) < node.PrefixLen {
+			break
+		}
+
+		if !node.isIm() {
+			v := node.value.Load().v
+			if e, isExpiring := v.(*expiringValue); !isExpiring {
+				val, ok = v, true
+			} else {
+				if now == 0 {
+					now = t.now().UnixNano()
+				}
+				if now < e.expires {
+					val, ok = e.v, true
+				} else {
+					expired := t.keyOf(&node.keyBits)
+					stale = &expired
+				}
+			}
+		}
+
+		// There is no bit after the max prefix length to descend by.
+		if node.PrefixLen == t.maxPrefixLen {
+			break
+		}
+	}
+
+	return val, ok, stale
+}
+
+func (t *lpmTrie) Sweep() (deleted int) {
+	if t.expiring.Load() == 0 {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var keys []Key
+	t.collectExpired(t.rootNode(), &keys)
+
+	for _, key := range keys {
+		if _, ok := t.delete(key); ok {
+			deleted++
+		}
+	}
+	return deleted
+}
+
+func (t *lpmTrie) collectExpired(node *lpmTrieNode, keys *[]Key) {
+	if node == nil {
+		return
+	}
+
+	t.collectExpired(t.childNode(node, 0), keys)
+	if !node.isIm() && t.expired(node) {
+		*keys = append(*keys, t.keyOf(&node.keyBits))
+	}
+	t.collectExpired(t.childNode(node, 1), keys)
+}
@@ -0,0 +1,251 @@
+package lpmtrie
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now atomic.Int64
+}
+
+func newFakeClock() *fakeClock {
+	var c fakeClock
+	c.now.Store(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano())
+	return &c
+}
+
+func (c *fakeClock) Now() time.Time {
+	return time.Unix(0, c.now.Load())
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.now.Add(int64(d))
+}
+
+func TestUpdateWithTTL(t *testing.T) {
+	for _, plen := range []int{MaxPrefixLenIPv4, MaxPrefixLenIPv6, 136} {
+		for _, c := range testBackends {
+			t.Run(fmt.Sprintf("%d/%s", plen, c.name), func(t *testing.T) {
+				key := func(prefixLen int, data ...byte) Key {
+					k := Key{prefixLen, make([]byte, plen/8)}
+					copy(k.Data, data)
+					return k
+				}
+
+				clock := newFakeClock()
+				trie, _ := New(plen, append(c.opts, WithClock(clock.Now))...)
+
+				host := key(plen, 10, 1, 1, 1)
+				full := key(plen, 10, 2, 0, 1)
+				expiringFull := key(plen, 10, 3, 0, 1)
+				trie.Update(key(8, 10), "permanent")
+				trie.Update(full, "full")
+				trie.UpdateWithTTL(key(16, 10, 1), "short", time.Minute)
+				trie.UpdateWithTTL(key(24, 10, 1, 1), "long", time.Hour)
+				trie.UpdateWithTTL(expiringFull, "expiring full", time.Hour)
+
+				if v, _ := trie.Lookup(host); v != "long" {
+					t.Errorf("expected value long, got %v", v)
+				}
+				// The entries of the max prefix length are hit by both of
+				// the lookups.
+				if v, _ := trie.Lookup(full); v != "full" {
+					t.Errorf("expected value full, got %v", v)
+				}
+				if v, _ := trie.Lookup(expiringFull); v != "expiring full" {
+					t.Errorf("expected value expiring full, got %v", v)
+				}
+				vals, found := make([]interface{}, 3), make([]bool, 3)
+				trie.LookupBatch([]Key{host, full, expiringFull}, vals, found)
+				if vals[0] != "long" || vals[1] != "full" || vals[2] != "expiring full" {
+					t.Errorf("expected values long, full and expiring full by batch, got %v", vals)
+				}
+
+				clock.advance(time.Hour)
+				if v, _ := trie.Lookup(host); v != "permanent" {
+					t.Errorf("expected expired entries to be skipped, got %v", v)
+				}
+				// The lookup reaped the longest expired entry only.
+				if trie.Size() != 4 {
+					t.Errorf("expected size 4, got %d", trie.Size())
+				}
+				if v, _ := trie.Lookup(expiringFull); v != "permanent" {
+					t.Errorf("expected expired entry of max prefix length to be skipped, got %v", v)
+				}
+				if deleted := trie.Sweep(); deleted != 1 {
+					t.Errorf("expected 1 expired entry to be swept, got %d", deleted)
+				}
+
+				trie.UpdateWithTTL(key(16, 10, 1), "short", time.Minute)
+				trie.UpdateWithTTL(key(24, 10, 1, 1), "long", time.Minute)
+				clock.advance(time.Minute)
+				trie.LookupBatch([]Key{host, full, expiringFull}, vals, found)
+				if vals[0] != "permanent" || vals[1] != "full" || vals[2] != "permanent" {
+					t.Errorf("expected expired entries to be skipped by batch, got %v", vals)
+				}
+				n := 0
+				trie.Range(func(key Key, val interface{}) bool {
+					n++
+					return true
+				})
+				if n != 2 {
+					t.Errorf("expected 2 entries in range, got %d", n)
+				}
+				trie.Supernets(host, func(key Key, val interface{}) bool {
+					if val != "permanent" {
+						t.Errorf("expected expired entries to be skipped by supernets, got %v", val)
+					}
+					return true
+				})
+
+				// The batch lookup reaped one of them.
+				if deleted := trie.Sweep(); deleted != 1 {
+					t.Errorf("expected 1 expired entry to be swept, got %d", deleted)
+				}
+				if trie.Sweep() != 0 {
+					t.Errorf("expected no expired entry left")
+				}
+				if trie.Size() != 2 {
+					t.Errorf("expected size 2, got %d", trie.Size())
+				}
+				// The lookups take the fast paths again.
+				if n := trie.(*lpmTrie).expiring.Load(); n != 0 {
+					t.Errorf("expected no entry with a TTL, got %d", n)
+				}
+				if v, _ := trie.Lookup(full); v != "full" {
+					t.Errorf("expected value full, got %v", v)
+				}
+				if err := trie.Validate(); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestTTLWriters(t *testing.T) {
+	const plen = 32
+
+	clock := newFakeClock()
+	var o countingObserver
+	trie, _ := New(plen, WithClock(clock.Now), WithObserver(&o))
+	key := Key{16, []byte{10, 1, 0, 0}}
+	host := Key{plen, []byte{10, 1, 0, 1}}
+
+	if trie.UpdateWithTTL(key, 1, time.Minute) {
+		t.Errorf("expected key to be inserted")
+	}
+	if !trie.UpdateWithTTL(key, 2, time.Minute) {
+		t.Errorf("expected value to be replaced")
+	}
+
+	// Upsert and CompareAndSwap keep the expiry.
+	trie.Upsert(key, func(old interface{}, exists bool) interface{} {
+		return old.(int) + 1
+	})
+	if !trie.CompareAndSwap(key, 3, 4) {
+		t.Errorf("expected swap to succeed")
+	}
+	clock.advance(time.Minute)
+	if _, ok := trie.Lookup(host); ok {
+		t.Errorf("expected entry to expire")
+	}
+
+	// An expired entry is absent for writers.
+	trie.UpdateWithTTL(key, 1, time.Minute)
+	clock.advance(time.Minute)
+	if trie.CompareAndSwap(key, 1, 2) {
+		t.Errorf("expected swap of expired entry to fail")
+	}
+	if trie.Update(key, 2) {
+		t.Errorf("expected expired entry to be inserted again")
+	}
+	if n := trie.(*lpmTrie).expiring.Load(); n != 0 {
+		t.Errorf("expected no entry with a TTL after replace, got %d", n)
+	}
+	clock.advance(time.Hour)
+	if v, _ := trie.Lookup(host); v != 2 {
+		t.Errorf("expected entry to be permanent, got %v", v)
+	}
+
+	trie.UpdateWithTTL(key, 3, time.Minute)
+	clock.advance(time.Minute)
+	if v, loaded := trie.LoadOrStore(key, 4); loaded || v != 4 {
+		t.Errorf("expected value 4 to be stored, got %v", v)
+	}
+	trie.UpdateWithTTL(key, 5, time.Minute)
+	clock.advance(time.Minute)
+	if _, loaded := trie.LoadAndDelete(key); loaded {
+		t.Errorf("expected delete of expired entry to fail")
+	}
+	trie.UpdateWithTTL(key, 6, time.Minute)
+	clock.advance(time.Minute)
+	if trie.Delete(key) {
+		t.Errorf("expected delete of expired entry to fail")
+	}
+	if trie.Size() != 0 {
+		t.Errorf("expected empty trie, got size %d", trie.Size())
+	}
+	if n := trie.(*lpmTrie).expiring.Load(); n != 0 {
+		t.Errorf("expected no entry with a TTL, got %d", n)
+	}
+
+	if o.updates != [2]int{5, 5} {
+		t.Errorf("expected 5 inserts and 5 replaces, got %v", o.updates)
+	}
+}
+
+func TestTTLDefault(t *testing.T) {
+	clock := newFakeClock()
+	trie, _ := New(MaxPrefixLenIPv4, WithClock(clock.Now))
+
+	trie.UpdateWithTTL(Key{0, []byte{0, 0, 0, 0}}, "default", time.Second)
+	if _, ok := trie.Default(); !ok {
+		t.Errorf("expected default route")
+	}
+	clock.advance(time.Second)
+	if _, ok := trie.Default(); ok {
+		t.Errorf("expected default route to expire")
+	}
+}
+
+func TestTTLConcurrent(t *testing.T) {
+	const plen = 32
+
+	clock := newFakeClock()
+	trie, _ := New(plen, WithClock(clock.Now), WithArena())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := Key{24, []byte{10, byte(i), byte(j), 0}}
+				trie.UpdateWithTTL(key, j, time.Duration(1+j%10)*time.Millisecond)
+				trie.Lookup(Key{plen, []byte{10, byte(i), byte(j), 1}})
+				clock.advance(time.Millisecond)
+				if j%100 == 0 {
+					trie.Sweep()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	clock.advance(time.Second)
+	trie.Sweep()
+	if trie.Size() != 0 {
+		t.Errorf("expected every entry to expire, got size %d", trie.Size())
+	}
+	if n := trie.(*lpmTrie).expiring.Load(); n != 0 {
+		t.Errorf("expected no entry with a TTL, got %d", n)
+	}
+	if err := trie.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
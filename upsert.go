@@ -0,0 +1,54 @@
+package lpmtrie
+
+import "time"
+
+// entryNode returns the node of the entry of exactly the key, or nil if the
+// key is not in the trie or has expired.
+func (t *lpmTrie) entryNode(key Key) (node *lpmTrieNode) {
+	t.supernets(key, func(n *lpmTrieNode) bool {
+		if n.PrefixLen == key.PrefixLen && !t.expired(n) {
+			node = n
+		}
+		return true
+	})
+	return node
+}
+
+func (t *lpmTrie) Upsert(key Key, fn func(old interface{}, exists bool) interface{}) (updated bool) {
+	t.checkKey(key)
+
+	if t.observer != nil {
+		defer t.observeUpdate(time.Now(), &updated)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if node := t.entryNode(key); node != nil {
+		// The node keeps its children and its slot in the stride table,
+		// only the value is replaced.
+		node.replaceValue(fn(node.loadValue(), true))
+		return true
+	}
+
+	return t.update(key, fn(nil, false))
+}
+
+func (t *lpmTrie) CompareAndSwap(key Key, old, new interface{}) (swapped bool) {
+	t.checkKey(key)
+
+	if t.observer != nil {
+		defer t.observeSwap(time.Now(), &swapped)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	node := t.entryNode(key)
+	if node == nil || node.loadValue() != old {
+		return false
+	}
+
+	node.replaceValue(new)
+	return true
+}
@@ -0,0 +1,136 @@
+package lpmtrie
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestUpsert(t *testing.T) {
+	const plen = 32
+
+	add := func(n int) func(old interface{}, exists bool) interface{} {
+		return func(old interface{}, exists bool) interface{} {
+			if !exists {
+				return n
+			}
+			return old.(int) + n
+		}
+	}
+
+	for _, c := range testBackends {
+		t.Run(c.name, func(t *testing.T) {
+			trie, _ := New(plen, c.opts...)
+			impl := trie.(*lpmTrie)
+
+			key := Key{16, []byte{10, 1, 0, 0}}
+			if trie.Upsert(key, add(1)) {
+				t.Errorf("expected key to be inserted")
+			}
+			trie.Update(Key{24, []byte{10, 1, 1, 0}}, "child")
+			node := impl.entryNode(key)
+
+			if !trie.Upsert(key, add(2)) {
+				t.Errorf("expected value to be updated")
+			}
+			if v, _ := trie.Lookup(Key{plen, []byte{10, 1, 2, 1}}); v != 3 {
+				t.Errorf("expected value 3, got %v", v)
+			}
+			if impl.entryNode(key) != node {
+				t.Errorf("expected node to be kept")
+			}
+			if v, _ := trie.Lookup(Key{plen, []byte{10, 1, 1, 1}}); v != "child" {
+				t.Errorf("expected child to be kept, got %v", v)
+			}
+
+			// An intermediate node isn't an entry.
+			trie.Update(Key{24, []byte{10, 1, 128, 0}}, "sibling")
+			trie.Delete(key)
+			if trie.Upsert(Key{16, []byte{10, 1, 0, 0}}, add(5)) {
+				t.Errorf("expected key of intermediate node to be inserted")
+			}
+			if v, _ := trie.Lookup(Key{plen, []byte{10, 1, 2, 1}}); v != 5 {
+				t.Errorf("expected value 5, got %v", v)
+			}
+			if trie.Size() != 3 {
+				t.Errorf("expected size 3, got %d", trie.Size())
+			}
+			if err := trie.Validate(); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestUpsertConcurrent(t *testing.T) {
+	const plen = 32
+	const writers, increments = 8, 1000
+
+	trie, _ := New(plen, WithBackend(BackendStride))
+	keys := []Key{
+		{0, []byte{0, 0, 0, 0}},
+		{8, []byte{10, 0, 0, 0}},
+		{24, []byte{10, 1, 1, 0}},
+		{32, []byte{10, 1, 1, 1}},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				trie.Upsert(keys[j%len(keys)], func(old interface{}, exists bool) interface{} {
+					if !exists {
+						return 1
+					}
+					return old.(int) + 1
+				})
+				trie.Lookup(Key{plen, []byte{10, 1, 1, 1}})
+			}
+		}()
+	}
+	wg.Wait()
+
+	count := 0
+	trie.Range(func(key Key, val interface{}) bool {
+		count += val.(int)
+		return true
+	})
+	if count != writers*increments {
+		t.Errorf("expected %d increments, got %d", writers*increments, count)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	const plen = 32
+
+	var o countingObserver
+	trie, _ := New(plen, WithObserver(&o))
+	key := Key{16, []byte{10, 1, 0, 0}}
+
+	if trie.CompareAndSwap(key, nil, 1) {
+		t.Errorf("expected swap of absent key to fail")
+	}
+
+	trie.Update(key, 1)
+	trie.Update(Key{24, []byte{10, 1, 1, 0}}, 2)
+	if trie.CompareAndSwap(key, 2, 3) {
+		t.Errorf("expected swap of different value to fail")
+	}
+	if trie.CompareAndSwap(Key{8, []byte{10, 0, 0, 0}}, 1, 3) {
+		t.Errorf("expected swap of covering key to fail")
+	}
+	if !trie.CompareAndSwap(key, 1, 3) {
+		t.Errorf("expected swap to succeed")
+	}
+	if v, _ := trie.Lookup(Key{plen, []byte{10, 1, 0, 1}}); v != 3 {
+		t.Errorf("expected value 3, got %v", v)
+	}
+	if v, _ := trie.Lookup(Key{plen, []byte{10, 1, 1, 1}}); v != 2 {
+		t.Errorf("expected child value 2, got %v", v)
+	}
+
+	if o.updates != [2]int{2, 1} {
+		t.Errorf("expected 2 inserts and 1 replace, got %v", o.updates)
+	}
+}
@@ -0,0 +1,76 @@
+package lpmtrie
+
+import "fmt"
+
+// Validate checks the invariants of the structure of the trie, which are:
+//
+//   - the prefix length of each node is at most the max prefix length;
+//   - each child is more specific than its parent, and extends the prefix of
+//     its parent;
+//   - each child is in the slot of its bit at the prefix length of its
+//     parent;
+//   - each intermediate node has exactly two children;
+//   - no key is held by more than one node;
+//   - Size equals the number of the non-intermediate nodes.
+//
+// It returns an error describing the first broken invariant, or nil. Writers
+// are blocked during the check.
+func (t *lpmTrie) Validate() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var entries int64
+	seen := make(map[string]struct{})
+	if err := t.validate(nil, 0, t.rootNode(), seen, &entries); err != nil {
+		return err
+	}
+
+	if size := t.Size(); size != entries {
+		return fmt.Errorf("lpmtrie: size is %d, but there are %d entries", size, entries)
+	}
+
+	return nil
+}
+
+// validate checks node at the bit slot of parent and its subtree, counting
+// the entries into entries.
+func (t *lpmTrie) validate(parent *lpmTrieNode, bit byte, node *lpmTrieNode, seen map[string]struct{}, entries *int64) error {
+	if node == nil {
+		return nil
+	}
+
+	key := t.keyString(&node.keyBits)
+	if _, ok := seen[key]; ok {
+		return fmt.Errorf("lpmtrie: duplicate key %s", key)
+	}
+	seen[key] = struct{}{}
+
+	if node.PrefixLen < 0 || node.PrefixLen > t.maxPrefixLen {
+		return fmt.Errorf("lpmtrie: prefix length of %s is out of range", key)
+	}
+
+	if parent != nil {
+		parentKey := t.keyString(&parent.keyBits)
+		if node.PrefixLen <= parent.PrefixLen ||
+			t.longestPrefixMatch(parent, node.keyBits) != parent.PrefixLen {
+			return fmt.Errorf("lpmtrie: %s doesn't extend its parent %s", key, parentKey)
+		}
+		if node.bit(parent.PrefixLen) != bit {
+			return fmt.Errorf("lpmtrie: %s is in the slot %d of its parent %s", key, bit, parentKey)
+		}
+	}
+
+	left, right := t.childNode(node, 0), t.childNode(node, 1)
+	if node.isIm() {
+		if left == nil || right == nil {
+			return fmt.Errorf("lpmtrie: intermediate node %s doesn't have two children", key)
+		}
+	} else {
+		*entries++
+	}
+
+	if err := t.validate(node, 0, left, seen, entries); err != nil {
+		return err
+	}
+	return t.validate(node, 1, right, seen, entries)
+}
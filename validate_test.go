@@ -0,0 +1,125 @@
+package lpmtrie
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	const plen = 32
+	var trie *lpmTrie
+
+	reset := func() {
+		lt, _ := New(plen)
+		trie = lt.(*lpmTrie)
+		trie.Update(Key{8, []byte{10, 0, 0, 0}}, 8)
+		trie.Update(Key{16, []byte{10, 1, 0, 0}}, 1)
+		trie.Update(Key{16, []byte{10, 2, 0, 0}}, 2)
+	}
+
+	// the intermediate node 10.0.0.0/14 under 10.0.0.0/8
+	imNode := func() *lpmTrieNode {
+		return trie.childNode(trie.rootNode(), 0)
+	}
+
+	expectError := func(t *testing.T, substr string) {
+		t.Helper()
+
+		err := trie.Validate()
+		if err == nil || !strings.Contains(err.Error(), substr) {
+			t.Errorf("expected error containing %q, got %v", substr, err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{
+			"valid",
+			func(t *testing.T) {
+				if err := trie.Validate(); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			"swapped children",
+			func(t *testing.T) {
+				n := imNode()
+				left, right := trie.childNode(n, 0), trie.childNode(n, 1)
+				trie.setChild(n, 0, right)
+				trie.setChild(n, 1, left)
+				expectError(t, "in the slot 0")
+			},
+		},
+		{
+			"intermediate node with one child",
+			func(t *testing.T) {
+				trie.setChild(imNode(), 1, nil)
+				atomic.AddInt64(&trie.size, -1)
+				expectError(t, "doesn't have two children")
+			},
+		},
+		{
+			"child not extending parent",
+			func(t *testing.T) {
+				trie.childNode(imNode(), 0).hi = uint64(11) << 56
+				expectError(t, "doesn't extend its parent")
+			},
+		},
+		{
+			"child not more specific",
+			func(t *testing.T) {
+				trie.childNode(imNode(), 0).PrefixLen = 12
+				expectError(t, "doesn't extend its parent")
+			},
+		},
+		{
+			"duplicate key",
+			func(t *testing.T) {
+				leaf := trie.childNode(imNode(), 0)
+				trie.setChild(leaf, 0, newLpmTrieNode(leaf.keyBits, 3))
+				expectError(t, "duplicate key 10.1.0.0/16")
+			},
+		},
+		{
+			"size mismatch",
+			func(t *testing.T) {
+				atomic.AddInt64(&trie.size, 1)
+				expectError(t, "size is 4, but there are 3 entries")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reset()
+			tt.run(t)
+		})
+	}
+}
+
+func TestValidateRandom(t *testing.T) {
+	for _, plen := range []int{MaxPrefixLenIPv4, MaxPrefixLenIPv6} {
+		table := SyntheticTable(1, 5000, plen)
+
+		for _, opts := range [][]Option{nil, {WithBackend(BackendStride)}, {WithArena()}} {
+			trie, _ := New(plen, opts...)
+			for i, key := range table {
+				trie.Update(key, i)
+			}
+			if err := trie.Validate(); err != nil {
+				t.Fatalf("unexpected error after updates: %v", err)
+			}
+
+			for _, key := range table[:len(table)/2] {
+				trie.Delete(key)
+			}
+			if err := trie.Validate(); err != nil {
+				t.Fatalf("unexpected error after deletes: %v", err)
+			}
+		}
+	}
+}